@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/omesh-barhate/coderpad/commons"
+	"github.com/omesh-barhate/coderpad/crdt"
+)
+
+// Clients periodically exchange digests of their documents' CRDT state to detect silent divergence.
+// When a peer keeps reporting a different digest, the room state is requested from the server, whose replica
+// is the source of truth, and replaces the local document. The state carries the characters' IDs, so later
+// operations keep referring to characters every replica has, which splicing the differing lines in wouldn't.
+
+const (
+	digestInterval = 10 * time.Second
+
+	// divergenceThreshold is the number of consecutive mismatching digests from a peer before repairing.
+	// A single mismatch is expected while operations are still in flight.
+	divergenceThreshold = 2
+)
+
+var (
+	clientID   uuid.UUID
+	divergence = make(map[uuid.UUID]int)
+	// stateRequested is set while a state request sent to repair the document is outstanding.
+	stateRequested bool
+)
+
+func sendDigest(connection *websocket.Conn) {
+	if !connected {
+		return
+	}
+	digest := crdt.NewDigest(document.Snapshot())
+	message := commons.Message{
+		Username:    username,
+		MessageType: commons.DigestMessage,
+		Digest:      &digest,
+	}
+	if err := connection.WriteJSON(&message); err != nil {
+		logger.Errorf("failed to send digest: %v\n", err)
+	}
+}
+
+func handleDigest(message commons.Message, connection *websocket.Conn) {
+	if message.Digest == nil {
+		return
+	}
+	local := crdt.NewDigest(document.Snapshot())
+	if local == *message.Digest {
+		delete(divergence, message.ClientID)
+		return
+	}
+	divergence[message.ClientID]++
+	logger.Warnf("digest mismatch with %s (%d in a row): local %s, remote %s\n",
+		message.Username, divergence[message.ClientID], local.Hash, message.Digest.Hash)
+	if stateRequested || divergence[message.ClientID] < divergenceThreshold {
+		return
+	}
+	ed.StatusMsg = fmt.Sprintf("document diverged from %s, repairing...", message.Username)
+	ed.SetStatusBar()
+	requestState(connection)
+}
+
+// requestState asks the server for the room state, to replace the local document with, unless a request is outstanding.
+func requestState(connection *websocket.Conn) {
+	if stateRequested {
+		return
+	}
+	stateRequested = true
+	clear(divergence)
+	if err := connection.WriteJSON(&commons.Message{Username: username, MessageType: commons.StateReqMessage}); err != nil {
+		logger.Errorf("failed to request the room state: %v\n", err)
+		stateRequested = false
+	}
+}
+
+// handleRepair reports a repair once the requested room state replaced the document.
+func handleRepair() {
+	if !stateRequested {
+		return
+	}
+	stateRequested = false
+	ed.StatusMsg = "document repaired from the server"
+	ed.SetStatusBar()
+}
@@ -30,6 +30,25 @@ type Editor struct {
 
 	// StatusMsg represents the text displayed in the status bar.
 	StatusMsg string
+
+	// Indicator is displayed at the right end of the status bar until it is cleared, for example to show that the session is read-only.
+	Indicator string
+
+	// Mark is the other end of the selection, which runs from the mark to the cursor, if Selecting is true.
+	Mark      int
+	Selecting bool
+
+	// RemoteCursors are the cursors and selections of the other users in the session.
+	RemoteCursors []RemoteCursor
+}
+
+// RemoteCursor is another user's cursor, drawn in their colour with their name at the end of its line.
+type RemoteCursor struct {
+	Name   string
+	Cursor int
+	// Mark is the other end of the user's selection; it equals Cursor if nothing is selected.
+	Mark  int
+	Color termbox.Attribute
 }
 
 // NewEditor returns a new instance of the editor.
@@ -80,6 +99,20 @@ func (e *Editor) SetSize(w, h int) {
 	e.Height = h
 }
 
+// ToggleMark starts a selection at the cursor, or clears the current one.
+func (e *Editor) ToggleMark() {
+	e.Selecting = !e.Selecting
+	e.Mark = e.Cursor
+}
+
+// Selection returns the start and end of the selection, if there is one.
+func (e *Editor) Selection() (int, int, bool) {
+	if !e.Selecting {
+		return 0, 0, false
+	}
+	return min(e.Mark, e.Cursor), max(e.Mark, e.Cursor), true
+}
+
 // AddRune adds a rune to the editor's existing content and updates the cursor position.
 func (e *Editor) AddRune(r rune) {
 	if e.Cursor == 0 {
@@ -107,19 +140,22 @@ func (e *Editor) Draw() {
 		} else {
 			if x < e.Width {
 				// Set cell content.
-				termbox.SetCell(x, y, e.Text[i], termbox.ColorDefault, termbox.ColorDefault)
+				foreground, background := e.cellColors(i)
+				termbox.SetCell(x, y, e.Text[i], foreground, background)
 			}
 
 			// Update x by rune's width.
 			x = x + runewidth.RuneWidth(e.Text[i])
 		}
 	}
+	e.showRemoteCursors()
 
 	if e.ShowMsg {
 		e.SetStatusBar()
 	} else {
 		e.showPositions()
 	}
+	e.showIndicator()
 
 	// Flush back buffer!
 	termbox.Flush()
@@ -140,6 +176,51 @@ func (e *Editor) SetStatusBar() {
 	})
 }
 
+// cellColors returns the colours of the character at index: highlighted if it is in the local selection,
+// or in the colour of a remote user whose selection it is in.
+func (e *Editor) cellColors(index int) (termbox.Attribute, termbox.Attribute) {
+	if start, end, ok := e.Selection(); ok && index >= start && index < end {
+		return termbox.AttrReverse, termbox.ColorDefault
+	}
+	for _, cursor := range e.RemoteCursors {
+		if index >= min(cursor.Mark, cursor.Cursor) && index < max(cursor.Mark, cursor.Cursor) {
+			return termbox.ColorBlack, cursor.Color
+		}
+	}
+	return termbox.ColorDefault, termbox.ColorDefault
+}
+
+// showRemoteCursors highlights the cell at each remote cursor, and shows the user's name at the right end of its line.
+func (e *Editor) showRemoteCursors() {
+	for _, cursor := range e.RemoteCursors {
+		x, y := e.calcCursorXY(cursor.Cursor)
+		if y > e.Height-1 {
+			continue
+		}
+		character := ' '
+		if cursor.Cursor < len(e.Text) && e.Text[cursor.Cursor] != '\n' {
+			character = e.Text[cursor.Cursor]
+		}
+		if x-1 < e.Width {
+			termbox.SetCell(x-1, y-1, character, termbox.ColorBlack, cursor.Color)
+		}
+		labelX := e.Width - runewidth.StringWidth(cursor.Name) - 1
+		for _, r := range cursor.Name {
+			termbox.SetCell(labelX, y-1, r, cursor.Color|termbox.AttrBold, termbox.ColorDefault)
+			labelX += runewidth.RuneWidth(r)
+		}
+	}
+}
+
+// showIndicator shows the indicator, highlighted, at the right end of the status bar.
+func (e *Editor) showIndicator() {
+	x := e.Width - runewidth.StringWidth(e.Indicator)
+	for _, r := range e.Indicator {
+		termbox.SetCell(x, e.Height-1, r, termbox.AttrReverse, termbox.ColorDefault)
+		x += runewidth.RuneWidth(r)
+	}
+}
+
 // showPositions shows the cursor positions with other details.
 func (e *Editor) showPositions() {
 	x, y := e.calcCursorXY(e.Cursor)
@@ -160,3 +160,24 @@ func TestMoveCursor(t *testing.T) {
 		}
 	}
 }
+
+func TestSelection(t *testing.T) {
+	e := NewEditor()
+	e.Text = []rune("selection")
+	e.Cursor = 6
+	if _, _, ok := e.Selection(); ok {
+		t.Errorf("expected no selection before setting the mark\n")
+	}
+
+	e.ToggleMark()
+	e.MoveCursor(-4, 0)
+	start, end, ok := e.Selection()
+	if got, expected := []int{start, end}, []int{2, 6}; !ok || !cmp.Equal(got, expected) {
+		t.Errorf("got != expected, diff: %v\n", cmp.Diff(got, expected))
+	}
+
+	e.ToggleMark()
+	if _, _, ok := e.Selection(); ok {
+		t.Errorf("expected no selection after clearing the mark\n")
+	}
+}
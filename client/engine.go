@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strconv"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/nsf/termbox-go"
 	"github.com/omesh-barhate/coderpad/commons"
@@ -21,7 +22,8 @@ func handleTermboxEvent(event termbox.Event, connection *websocket.Conn) error {
 			if fileName == "" {
 				fileName = "coderpad-content.txt"
 			}
-			err := crdt.Save(fileName, &document)
+			snapshot := document.Snapshot()
+			err := crdt.Save(fileName, &snapshot)
 			if err != nil {
 				ed.StatusMsg = "Failed to save to " + fileName
 				logrus.Errorf("failed to save to %s", fileName)
@@ -31,6 +33,9 @@ func handleTermboxEvent(event termbox.Event, connection *websocket.Conn) error {
 			ed.StatusMsg = "Saved document to " + fileName
 			ed.SetStatusBar()
 		case termbox.KeyCtrlL:
+			if refuseViewer() {
+				break
+			}
 			if fileName != "" {
 				logger.Log(logrus.InfoLevel, "LOADING DOCUMENT")
 				newDocument, err := crdt.Load(fileName)
@@ -42,16 +47,25 @@ func handleTermboxEvent(event termbox.Event, connection *websocket.Conn) error {
 					ed.SetStatusBar()
 					return err
 				}
-				document = newDocument
+				document.Replace(newDocument, localAuthor)
 				ed.SetX(0)
-				ed.SetText(crdt.Content(document))
 				logger.Log(logrus.InfoLevel, "SENDING DOCUMENT")
-				message := commons.Message{MessageType: commons.DocSyncMessage, Document: document}
-				_ = connection.WriteJSON(&message)
+				sendChange(commons.Message{MessageType: commons.DocSyncMessage, Document: newDocument}, connection)
 			} else {
 				ed.StatusMsg = "No file to load!"
 				ed.SetStatusBar()
 			}
+		case termbox.KeyCtrlK:
+			createCheckpoint()
+		case termbox.KeyCtrlD:
+			exportPatch(arguments.DiffRange)
+		case termbox.KeyCtrlU:
+			if arguments.PatchPath != "" {
+				applyPatch(arguments.PatchPath, connection)
+			} else {
+				ed.StatusMsg = "No patch to apply!"
+				ed.SetStatusBar()
+			}
 		case termbox.KeyArrowLeft, termbox.KeyCtrlB:
 			ed.MoveCursor(-1, 0)
 		case termbox.KeyArrowRight, termbox.KeyCtrlF:
@@ -68,8 +82,12 @@ func handleTermboxEvent(event termbox.Event, connection *websocket.Conn) error {
 			performOperation(OperationDelete, event, connection)
 		case termbox.KeyDelete:
 			performOperation(OperationDelete, event, connection)
+		case termbox.KeyCtrlR:
+			toggleReadOnly(connection)
+		case termbox.KeyCtrlSpace:
+			ed.ToggleMark()
 		case termbox.KeyTab:
-			for i := 0; i < 4; i++ {
+			for i := 0; i < tabWidth(); i++ {
 				event.Ch = ' '
 				performOperation(OperationInsert, event, connection)
 			}
@@ -95,34 +113,90 @@ const (
 )
 
 func performOperation(operationType int, event termbox.Event, connection *websocket.Conn) {
+	if refuseViewer() {
+		return
+	}
+	if padReadOnly() {
+		ed.StatusMsg = "Pad is read-only, press Ctrl+R to make it editable"
+		ed.SetStatusBar()
+		return
+	}
+	// Editing ends the selection, as the mark would no longer be where it was set.
+	ed.Selecting = false
 	character := string(event.Ch)
-	var message commons.Message
+	var operation commons.Operation
+	var ok bool
 	switch operationType {
 	case OperationInsert:
 		logger.Infof("LOCAL INSERT: %s at cursor position %v\n", character, ed.Cursor)
 		runes := []rune(character)
 		ed.AddRune(runes[0])
-		text, err := document.Insert(ed.Cursor, character)
-		if err != nil {
-			ed.SetText(text)
-			logger.Errorf("CRDT error: %v\n", err)
-		}
-		ed.SetText(text)
-		message = commons.Message{MessageType: "operation", Operation: commons.Operation{OperationType: "insert", Position: ed.Cursor, Value: character}}
+		operation, ok = applyLocalEdit(crdt.Edit{Type: crdt.ChangeInsert, Position: ed.Cursor, Value: character}, localAuthor)
 	case OperationDelete:
 		logger.Infof("LOCAL DELETE: cursor position %v\n", ed.Cursor)
 		if ed.Cursor-1 < 0 {
 			ed.Cursor = 0
 		}
-		text := document.Delete(ed.Cursor)
-		ed.SetText(text)
-		message = commons.Message{MessageType: "operation", Operation: commons.Operation{OperationType: "delete", Position: ed.Cursor}}
+		operation, ok = applyLocalEdit(crdt.Edit{Type: crdt.ChangeDelete, Position: ed.Cursor}, localAuthor)
 		ed.MoveCursor(-1, 0)
 	}
-	err := connection.WriteJSON(message)
-	if err != nil {
-		ed.StatusMsg = "lost connection!"
-		ed.SetStatusBar()
+	if ok {
+		sendOperation(operation, connection)
+	}
+}
+
+// applyLocalEdit applies an edit made on this client and returns the operation describing it to other replicas.
+func applyLocalEdit(edit crdt.Edit, author string) (commons.Operation, bool) {
+	operation := commons.Operation{OperationType: string(edit.Type), Position: edit.Position, Value: edit.Value}
+	switch edit.Type {
+	case crdt.ChangeInsert:
+		character, err := document.Insert(edit.Position, edit.Value, author)
+		if err != nil {
+			logger.Errorf("CRDT error: %v\n", err)
+			return operation, false
+		}
+		operation.Character = &character
+	case crdt.ChangeDelete:
+		character, ok := document.Delete(edit.Position, author)
+		if !ok {
+			return operation, false
+		}
+		operation.Character = &character
+	}
+	return operation, true
+}
+
+func sendOperation(operation commons.Operation, connection *websocket.Conn) {
+	sendChange(commons.Message{Username: username, MessageType: "operation", Operation: operation}, connection)
+}
+
+// localAuthor is the author of changes made on this client. Remote changes are authored by their sender's username,
+// which another user may share, so local changes are told apart by an identity unique to this process.
+var localAuthor = uuid.NewString()
+
+// handleDocumentChange keeps the editor in sync with the document.
+// Remote changes before the cursor shift it, so the local user keeps typing at the same spot.
+func handleDocumentChange(change crdt.Change) {
+	ed.SetText(document.Content())
+	updateRemoteCursors()
+	if change.Author == localAuthor {
+		return
+	}
+	switch change.Type {
+	case crdt.ChangeInsert:
+		if change.Position-1 < ed.Cursor {
+			ed.MoveCursor(1, 0)
+		}
+		if change.Position-1 < ed.Mark {
+			ed.Mark++
+		}
+	case crdt.ChangeDelete:
+		if change.Position-1 < ed.Cursor {
+			ed.MoveCursor(-1, 0)
+		}
+		if change.Position-1 < ed.Mark {
+			ed.Mark--
+		}
 	}
 }
 
@@ -137,54 +211,141 @@ func getTermboxChan() chan termbox.Event {
 }
 
 func handleMsg(message commons.Message, connection *websocket.Conn) {
+	lastSequence = max(lastSequence, message.Sequence)
 	switch message.MessageType {
+	case commons.AckMessage:
+		acknowledge(message.ChangeID)
+		return
 	case commons.DocSyncMessage:
 		logger.Infof("DOCSYNC RECEIVED, updating local document %+v\n", message.Document)
-		document = message.Document
-	case commons.DocReqMessage:
-		logger.Infof("DOCREQ RECEIVED, sending local document to %v\n", message.ClientID)
-		response := commons.Message{MessageType: commons.DocSyncMessage, Document: document, ClientID: message.ClientID}
-		_ = connection.WriteJSON(&response)
+		// A resync carries the latest change the server answered, whose ack was dropped with the queued messages.
+		acknowledge(message.ChangeID)
+		document.Replace(message.Document, message.Username)
+		reintegrateOutbox()
+		handleRepair()
+		if message.Metadata != nil {
+			metadata.Merge(message.Metadata)
+		}
+		if initialDocument != nil && isViewer() {
+			ed.StatusMsg = "You joined as a viewer, the file was not loaded"
+			ed.SetStatusBar()
+			initialDocument = nil
+		}
+		if initialDocument != nil {
+			document.Replace(*initialDocument, localAuthor)
+			sendChange(commons.Message{Username: username, MessageType: commons.DocSyncMessage, Document: *initialDocument}, connection)
+			initialDocument = nil
+		}
 	case commons.SiteIDMessage:
 		siteID, err := strconv.Atoi(message.Text)
 		if err != nil {
 			logger.Errorf("failed to set siteID, err: %v\n", err)
 		}
 		crdt.SiteID = siteID
+		// A resumed site must not generate the IDs of its characters already in the document again.
+		crdt.AdvanceLocalClock(message.Clock)
+		clientID = message.ClientID
+		setRole(message.Role)
+		metadataClock.SetSite(siteID)
+		publishMetadata(connection)
+		loadInitialFile()
 		logger.Infof("SITE ID %v, INTENDED SITE ID: %v", crdt.SiteID, siteID)
+	case commons.MetadataMessage:
+		handleMetadata(message)
+	case commons.DigestMessage:
+		handleDigest(message, connection)
+	case commons.PresenceMessage:
+		handlePresence(message)
+	case commons.LeaveMessage:
+		handleLeave(message)
 	case commons.JoinMessage:
 		ed.StatusMsg = fmt.Sprintf("%s has joined the session!", message.Username)
 		ed.SetStatusBar()
-	default:
-		switch message.Operation.OperationType {
-		case "insert":
-			_, err := document.Insert(message.Operation.Position, message.Operation.Value)
-			if err != nil {
-				logger.Errorf("failed to insert, err: %v\n", err)
-			}
-			logger.Infof("REMOTE INSERT: %s at position %v\n", message.Operation.Value, message.Operation.Position)
-		case "delete":
-			_ = document.Delete(message.Operation.Position)
-			logger.Infof("REMOTE DELETE: position %v\n", message.Operation.Position)
+	case commons.ErrorMessage:
+		handleError(message, connection)
+	case "operation":
+		if err := message.Operation.Apply(document, message.Username); err != nil {
+			logger.Errorf("failed to apply %s, err: %v\n", message.Operation.OperationType, err)
 		}
+		logger.Infof("REMOTE %s: %q at position %v\n", message.Operation.OperationType, message.Operation.Value, message.Operation.Position)
+	default:
+		logger.Warnf("ignoring message of unknown type %q\n", message.MessageType)
 	}
-	printDocument(document)
-	ed.SetText(crdt.Content(document))
+	checkDocument()
+	printDocument(document.Snapshot())
 	ed.Draw()
 }
 
+// loadInitialFile loads the -file, now that the client has its site ID.
+func loadInitialFile() {
+	if initialFile == "" {
+		return
+	}
+	loadedDocument, err := crdt.Load(initialFile)
+	initialFile = ""
+	if err != nil {
+		logger.Errorf("failed to load document: %v\n", err)
+		ed.StatusMsg = fmt.Sprintf("failed to load %s: %v", arguments.FilePath, err)
+		ed.SetStatusBar()
+		return
+	}
+	initialDocument = &loadedDocument
+}
+
+// handleError shows an error the server replied with in the status bar.
+// If the server rejected a change, the change is dropped from the outbox, and as the local document applied it,
+// the room state is requested to undo it.
+func handleError(message commons.Message, connection *websocket.Conn) {
+	if message.Error == nil {
+		return
+	}
+	logger.Warnf("server error %s: %s\n", message.Error.Code, message.Error.Text)
+	if message.ChangeID != 0 {
+		acknowledge(message.ChangeID)
+		requestState(connection)
+	}
+	switch message.Error.Code {
+	case commons.ErrorRateLimited:
+		ed.StatusMsg = "Typing too fast for the server, your changes are delayed"
+	case commons.ErrorMessageTooLarge:
+		ed.StatusMsg = "Change too large for the server: " + message.Error.Text
+	case commons.ErrorForbidden:
+		ed.StatusMsg = "Not allowed: " + message.Error.Text
+	default:
+		ed.StatusMsg = fmt.Sprintf("Server rejected a message (%s): %s", message.Error.Code, message.Error.Text)
+	}
+	ed.SetStatusBar()
+}
+
+// disconnectReason is why the server closed the connection, if it said so, and is read once the message channel is closed.
+// disconnectFinal is set if the client must not reconnect.
+var (
+	disconnectReason string
+	disconnectFinal  bool
+)
+
+// getMsgChan reads messages from the connection until it fails, and then closes the channel.
 func getMsgChan(connection *websocket.Conn) chan commons.Message {
 	messageChannel := make(chan commons.Message)
+	watchHeartbeat(connection)
 	go func() {
+		defer close(messageChannel)
 		for {
 			var message commons.Message
 			err := connection.ReadJSON(&message)
 			if err != nil {
-				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				var closeErr *websocket.CloseError
+				if errors.As(err, &closeErr) && closeErr.Code == websocket.CloseServiceRestart {
+					disconnectReason = "server restarting"
+				} else if errors.As(err, &closeErr) && (closeErr.Code == commons.CloseKicked || closeErr.Code == commons.CloseRoomClosed) {
+					// The client was kicked, or the room closed, so reconnecting would be unwelcome.
+					disconnectReason, disconnectFinal = closeErr.Text, true
+				} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 					logger.Errorf("websocket error: %v", err)
 				}
 				break
 			}
+			extendDeadline(connection)
 			logger.Infof("message received: %+v\n", message)
 			messageChannel <- message
 		}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The client pings the server every -ping-interval, and considers the connection dead if nothing,
+// not even a pong, arrives for -pong-timeout. The round trip time of the last ping is shown in the status bar.
+
+var (
+	// lastPong and roundTrip are updated by the goroutine reading the connection, in nanoseconds.
+	lastPong  atomic.Int64
+	roundTrip atomic.Int64
+	// connected is false from when the connection is lost until the client reconnects.
+	connected = true
+)
+
+// sendPing sends a ping carrying the time it was sent, so the pong tells the round trip time.
+func sendPing(connection *websocket.Conn) {
+	if !connected {
+		updateIndicator()
+		return
+	}
+	payload := []byte(fmt.Sprint(time.Now().UnixNano()))
+	if err := connection.WriteControl(websocket.PingMessage, payload, time.Now().Add(arguments.PongTimeout)); err != nil {
+		logger.Errorf("failed to send ping: %v\n", err)
+	}
+	updateIndicator()
+}
+
+// watchHeartbeat sets a read deadline on the connection, extended by every pong and by every message read with extendDeadline.
+func watchHeartbeat(connection *websocket.Conn) {
+	lastPong.Store(time.Now().UnixNano())
+	extendDeadline(connection)
+	connection.SetPongHandler(func(payload string) error {
+		now := time.Now().UnixNano()
+		var sent int64
+		if _, err := fmt.Sscan(payload, &sent); err == nil && sent <= now {
+			roundTrip.Store(now - sent)
+		}
+		lastPong.Store(now)
+		return connection.SetReadDeadline(time.Now().Add(arguments.PongTimeout))
+	})
+}
+
+func extendDeadline(connection *websocket.Conn) {
+	_ = connection.SetReadDeadline(time.Now().Add(arguments.PongTimeout))
+}
+
+// connectionHealth describes the connection for the status bar.
+func connectionHealth() string {
+	if !connected {
+		return "✕ offline"
+	}
+	silence := time.Since(time.Unix(0, lastPong.Load()))
+	if silence > 2*arguments.PingInterval {
+		return fmt.Sprintf("○ no reply for %s", silence.Truncate(time.Second))
+	}
+	return fmt.Sprintf("● %s", time.Duration(roundTrip.Load()).Round(time.Millisecond))
+}
+
+// updateIndicator shows the connection health, and the role if this client is a viewer, at the right of the status bar.
+func updateIndicator() {
+	parts := []string{connectionHealth()}
+	if isViewer() {
+		parts = append(parts, "VIEWER (read-only)")
+	}
+	ed.Indicator = " " + strings.Join(parts, " | ") + " "
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"net/http"
 	"os"
 	"strings"
 
@@ -14,29 +15,45 @@ import (
 )
 
 var (
-	document  = crdt.New()
+	document  = crdt.NewSyncedDocument(crdt.New())
 	logger    = logrus.New()
 	ed        = editor.NewEditor()
+	username  string
 	fileName  string
 	arguments Arguments
+
+	// initialFile is the -file to load once the server has issued this client's site ID,
+	// so its characters' IDs are of the client's site.
+	initialFile string
+	// initialDocument is the document loaded from initialFile. It replaces the room's document
+	// once the server has sent its current state.
+	initialDocument *crdt.Document
 )
 
 func main() {
 	arguments = parseFlags()
+	if arguments.PingInterval <= 0 || arguments.PongTimeout <= arguments.PingInterval {
+		fmt.Println("-pong-timeout must be longer than -ping-interval, which must be positive, exiting.")
+		return
+	}
 	scanner := bufio.NewScanner(os.Stdin)
-	userName := randomdata.SillyName()
+	username = randomdata.SillyName()
 	if arguments.RequireLogin {
 		fmt.Print("Enter your name: ")
 		scanner.Scan()
-		userName = scanner.Text()
+		username = scanner.Text()
 	}
-	connection, _, err := createConnection(arguments)
+	connection, response, err := createConnection(arguments, 0)
 	if err != nil {
+		if response != nil && response.StatusCode == http.StatusUnauthorized {
+			fmt.Println("Connection refused: a valid -token for this room is required, exiting.")
+			return
+		}
 		fmt.Printf("Connection error, exiting: %s\n", err)
 		return
 	}
 	defer connection.Close()
-	joinMessage := commons.Message{Username: userName, Text: "has joined the session.", MessageType: commons.JoinMessage}
+	joinMessage := commons.Message{Username: username, Text: "has joined the session.", MessageType: commons.JoinMessage}
 	_ = connection.WriteJSON(joinMessage)
 	logFile, debugLogFile, err := setupLogger(logger)
 	if err != nil {
@@ -44,12 +61,12 @@ func main() {
 		return
 	}
 	defer closeLogFiles(logFile, debugLogFile)
-	document = crdt.New()
 	if arguments.FilePath != "" {
-		if document, err = crdt.Load(arguments.FilePath); err != nil {
+		if _, err := os.ReadFile(arguments.FilePath); err != nil {
 			fmt.Printf("failed to load document: %s\n", err)
 			return
 		}
+		initialFile = arguments.FilePath
 	}
 	err = UI(connection)
 	if err != nil {
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+	"github.com/omesh-barhate/coderpad/commons"
+	"github.com/omesh-barhate/coderpad/crdt"
+)
+
+// Session metadata (title, language, tab width and the read-only flag) is kept in an observed-remove map
+// of last-writer-wins registers, so concurrent edits by different users converge on every client.
+
+const defaultTabWidth = 4
+
+var (
+	metadata      = crdt.NewMap()
+	metadataClock = crdt.NewClock(0)
+
+	metadataPublished bool
+)
+
+// setMetadata changes a metadata key locally and sends the change to the other clients.
+func setMetadata(key, value string, connection *websocket.Conn) {
+	operation := metadata.Put(key, value, metadataClock.Now())
+	sendChange(commons.Message{Username: username, MessageType: commons.MetadataMessage, MetadataOp: &operation}, connection)
+}
+
+// publishMetadata sets the metadata given on the command line, once the site ID is known.
+// It only does so when first joining, so reconnecting doesn't override changes made since.
+func publishMetadata(connection *websocket.Conn) {
+	if isViewer() || metadataPublished {
+		return
+	}
+	metadataPublished = true
+	if arguments.Title != "" {
+		setMetadata(commons.MetadataTitle, arguments.Title, connection)
+	}
+	if arguments.Language != "" {
+		setMetadata(commons.MetadataLanguage, arguments.Language, connection)
+	}
+	if arguments.TabWidth > 0 {
+		setMetadata(commons.MetadataTabWidth, strconv.Itoa(arguments.TabWidth), connection)
+	}
+}
+
+func handleMetadata(message commons.Message) {
+	if message.MetadataOp == nil {
+		return
+	}
+	metadataClock.Update(message.MetadataOp.Timestamp)
+	if !metadata.Apply(*message.MetadataOp) {
+		return
+	}
+	value, ok := metadata.Get(message.MetadataOp.Key)
+	if !ok {
+		ed.StatusMsg = fmt.Sprintf("%s cleared %s", message.Username, message.MetadataOp.Key)
+	} else {
+		ed.StatusMsg = fmt.Sprintf("%s set %s to %s", message.Username, message.MetadataOp.Key, value)
+	}
+	ed.SetStatusBar()
+}
+
+func toggleReadOnly(connection *websocket.Conn) {
+	if refuseViewer() {
+		return
+	}
+	readOnly := !padReadOnly()
+	setMetadata(commons.MetadataReadOnly, strconv.FormatBool(readOnly), connection)
+	if readOnly {
+		ed.StatusMsg = "Pad is now read-only"
+	} else {
+		ed.StatusMsg = "Pad is now editable"
+	}
+	ed.SetStatusBar()
+}
+
+func padReadOnly() bool {
+	value, _ := metadata.Get(commons.MetadataReadOnly)
+	readOnly, _ := strconv.ParseBool(value)
+	return readOnly
+}
+
+func tabWidth() int {
+	value, _ := metadata.Get(commons.MetadataTabWidth)
+	width, err := strconv.Atoi(value)
+	if err != nil || width <= 0 {
+		return defaultTabWidth
+	}
+	return width
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/omesh-barhate/coderpad/crdt"
+)
+
+// checkpoint is a named copy of the document content, taken with Ctrl+K.
+// The changes between two checkpoints, or since the latest one, can be exported as a unified diff with Ctrl+D.
+type checkpoint struct {
+	Name    string
+	Content string
+}
+
+// patchAuthor is the author of changes made by applying a patch, so they move the cursor like remote edits.
+const patchAuthor = "patch"
+
+var checkpoints []checkpoint
+
+func createCheckpoint() {
+	name := fmt.Sprintf("checkpoint-%d", len(checkpoints)+1)
+	checkpoints = append(checkpoints, checkpoint{Name: name, Content: document.Content()})
+	ed.StatusMsg = "Created " + name
+	ed.SetStatusBar()
+}
+
+// exportPatch writes the changes between the versions in diffRange, FROM..TO, as a unified diff.
+// Without a range, it writes the changes between the latest checkpoint (or the empty document) and the current content.
+func exportPatch(diffRange string) {
+	fromName, toName := "", "current"
+	if diffRange != "" {
+		var found bool
+		if fromName, toName, found = strings.Cut(diffRange, ".."); !found {
+			ed.StatusMsg = fmt.Sprintf("Invalid diff range %q, expected FROM..TO", diffRange)
+			ed.SetStatusBar()
+			return
+		}
+	}
+	from, err := findVersion(fromName)
+	if err == nil {
+		var to checkpoint
+		if to, err = findVersion(toName); err == nil {
+			writePatch(from, to)
+			return
+		}
+	}
+	ed.StatusMsg = "Failed to export patch: " + err.Error()
+	ed.SetStatusBar()
+}
+
+// findVersion returns the version of the document called name: a checkpoint's name or number, "empty" or "current".
+// An empty name is the latest checkpoint, or the empty document if there is none.
+func findVersion(name string) (checkpoint, error) {
+	switch name {
+	case "":
+		if len(checkpoints) == 0 {
+			return checkpoint{Name: "empty"}, nil
+		}
+		return checkpoints[len(checkpoints)-1], nil
+	case "empty":
+		return checkpoint{Name: "empty"}, nil
+	case "current":
+		return checkpoint{Name: fmt.Sprintf("current (%s)", time.Now().Format(time.RFC3339)), Content: document.Content()}, nil
+	}
+	if number, err := strconv.Atoi(name); err == nil {
+		if number < 1 || number > len(checkpoints) {
+			return checkpoint{}, fmt.Errorf("no checkpoint %d, there are %d", number, len(checkpoints))
+		}
+		return checkpoints[number-1], nil
+	}
+	for _, c := range checkpoints {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return checkpoint{}, fmt.Errorf("no checkpoint %q", name)
+}
+
+// writePatch writes the changes between two versions to a patch file named after them.
+func writePatch(from, to checkpoint) {
+	patch := crdt.UnifiedDiff(from.Name, to.Name, from.Content, to.Content)
+	if patch == "" {
+		ed.StatusMsg = fmt.Sprintf("No changes between %s and %s", from.Name, to.Name)
+		ed.SetStatusBar()
+		return
+	}
+	// The current version's name carries a timestamp, which is left out of file names.
+	label := func(version checkpoint) string {
+		if strings.HasPrefix(version.Name, "current") {
+			return "current"
+		}
+		return version.Name
+	}
+	patchFile := fmt.Sprintf("coderpad-%s.patch", label(from))
+	if label(to) != "current" {
+		patchFile = fmt.Sprintf("coderpad-%s-%s.patch", label(from), label(to))
+	}
+	if err := os.WriteFile(patchFile, []byte(patch), 0644); err != nil {
+		ed.StatusMsg = "Failed to export patch to " + patchFile
+		logger.Errorf("failed to export patch to %s: %v\n", patchFile, err)
+		ed.SetStatusBar()
+		return
+	}
+	ed.StatusMsg = fmt.Sprintf("Exported changes between %s and %s to %s", from.Name, to.Name, patchFile)
+	ed.SetStatusBar()
+}
+
+// applyPatch applies the unified diff in patchFile to the pad and sends the resulting operations.
+func applyPatch(patchFile string, connection *websocket.Conn) {
+	if refuseViewer() {
+		return
+	}
+	patch, err := os.ReadFile(patchFile)
+	if err != nil {
+		ed.StatusMsg = "Failed to read " + patchFile
+		logger.Errorf("failed to read patch %s: %v\n", patchFile, err)
+		ed.SetStatusBar()
+		return
+	}
+	current := document.Content()
+	patched, err := crdt.ApplyPatch(current, string(patch))
+	if err != nil {
+		ed.StatusMsg = fmt.Sprintf("Failed to apply %s: %v", patchFile, err)
+		ed.SetStatusBar()
+		logger.Errorf("failed to apply patch %s: %v\n", patchFile, err)
+		return
+	}
+	for _, edit := range crdt.Edits(current, patched) {
+		if operation, ok := applyLocalEdit(edit, patchAuthor); ok {
+			sendOperation(operation, connection)
+		}
+	}
+	ed.StatusMsg = "Applied " + patchFile
+	ed.SetStatusBar()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/nsf/termbox-go"
+	"github.com/omesh-barhate/coderpad/client/editor"
+	"github.com/omesh-barhate/coderpad/commons"
+	"github.com/omesh-barhate/coderpad/crdt"
+)
+
+// Each client tells the others where its cursor and selection are with presence messages.
+// The presence is checked on every presenceTicker tick, and only sent if it changed,
+// so moving the cursor quickly sends at most one message per presenceInterval.
+
+const presenceInterval = 100 * time.Millisecond
+
+// presenceColors is the palette of user colours; a presence's Color is an index in it.
+var presenceColors = []termbox.Attribute{termbox.ColorRed, termbox.ColorGreen, termbox.ColorYellow, termbox.ColorBlue, termbox.ColorMagenta, termbox.ColorCyan}
+
+type remoteUser struct {
+	username string
+	presence commons.Presence
+}
+
+var (
+	remoteUsers = make(map[uuid.UUID]remoteUser)
+	// sentPresence is the last presence sent to the other clients.
+	sentPresence commons.Presence
+)
+
+func localPresence() commons.Presence {
+	presence := commons.Presence{Cursor: document.Anchor(ed.Cursor), Color: crdt.SiteID % len(presenceColors)}
+	if ed.Selecting {
+		presence.Selection = document.Anchor(ed.Mark)
+	}
+	return presence
+}
+
+// sendPresence sends the local presence, if it changed since it was last sent.
+func sendPresence(connection *websocket.Conn) {
+	// The colour depends on the site ID, which the server hasn't sent yet.
+	if clientID == uuid.Nil || !connected {
+		return
+	}
+	presence := localPresence()
+	if presence == sentPresence {
+		return
+	}
+	message := commons.Message{Username: username, MessageType: commons.PresenceMessage, Presence: &presence}
+	if err := connection.WriteJSON(&message); err != nil {
+		logger.Errorf("failed to send presence: %v\n", err)
+		return
+	}
+	sentPresence = presence
+}
+
+func handlePresence(message commons.Message) {
+	if message.Presence == nil {
+		return
+	}
+	remoteUsers[message.ClientID] = remoteUser{username: message.Username, presence: *message.Presence}
+	updateRemoteCursors()
+}
+
+func handleLeave(message commons.Message) {
+	delete(remoteUsers, message.ClientID)
+	updateRemoteCursors()
+	if message.Username != "" {
+		ed.StatusMsg = fmt.Sprintf("%s has left the session.", message.Username)
+		ed.SetStatusBar()
+	}
+}
+
+// updateRemoteCursors places the remote users' cursors in the editor, from their anchors in the current document.
+func updateRemoteCursors() {
+	cursors := make([]editor.RemoteCursor, 0, len(remoteUsers))
+	for _, user := range remoteUsers {
+		cursor := document.AnchorPosition(user.presence.Cursor)
+		if cursor < 0 {
+			// The anchor was inserted by an operation this client hasn't received yet.
+			continue
+		}
+		mark := cursor
+		if user.presence.Selection != "" {
+			if position := document.AnchorPosition(user.presence.Selection); position >= 0 {
+				mark = position
+			}
+		}
+		color := presenceColors[0]
+		if user.presence.Color >= 0 {
+			color = presenceColors[user.presence.Color%len(presenceColors)]
+		}
+		cursors = append(cursors, editor.RemoteCursor{Name: user.username, Cursor: cursor, Mark: mark, Color: color})
+	}
+	// Draw overlapping selections in a stable order.
+	sort.Slice(cursors, func(i, j int) bool { return cursors[i].Name < cursors[j].Name })
+	ed.RemoteCursors = cursors
+}
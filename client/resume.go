@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/omesh-barhate/coderpad/commons"
+)
+
+// Changes sent to the server (operations, docSyncs and metadata) are numbered, and kept in the outbox until the server
+// acknowledges them by number. If the connection drops, the client reconnects with exponential backoff,
+// presents the sequence number of the last change it saw so the server only sends what it missed,
+// and sends the outbox again. Replaying an operation or metadata change the server already applied is harmless:
+// operations carry their character's ID, and metadata operations their timestamp. A docSync isn't replayed, as it
+// replaces the whole document: if the server applied it, replaying it would wipe every edit made since.
+
+const (
+	reconnectMinDelay = 500 * time.Millisecond
+	reconnectMaxDelay = 30 * time.Second
+)
+
+var (
+	outbox []commons.Message
+	// lastChangeID is the ID of the latest change sent.
+	lastChangeID uint64
+	// lastSequence is the sequence number of the latest change to the room this client has seen.
+	lastSequence uint64
+)
+
+// errUnauthorized stops reconnection attempts, as retrying with the same token won't help.
+var errUnauthorized = errors.New("the server no longer accepts the access token")
+
+type reconnection struct {
+	connection *websocket.Conn
+	err        error
+}
+
+// sendChange sends a message changing the pad, keeping it until the server acknowledges it.
+// While the client is disconnected, the message is only queued.
+func sendChange(message commons.Message, connection *websocket.Conn) {
+	lastChangeID++
+	message.ChangeID = lastChangeID
+	outbox = append(outbox, message)
+	if !connected {
+		return
+	}
+	if err := connection.WriteJSON(&message); err != nil {
+		ed.StatusMsg = "lost connection!"
+		ed.SetStatusBar()
+	}
+}
+
+// acknowledge drops the changes the server answered, up to changeID, from the outbox.
+func acknowledge(changeID uint64) {
+	answered := 0
+	for answered < len(outbox) && outbox[answered].ChangeID <= changeID {
+		answered++
+	}
+	outbox = outbox[answered:]
+}
+
+// reintegrateOutbox applies the unacknowledged local operations again, after the document was replaced by a docSync
+// that the server sent before it received them.
+func reintegrateOutbox() {
+	for _, message := range outbox {
+		if message.MessageType != "operation" {
+			continue
+		}
+		if err := message.Operation.Apply(document, localAuthor); err != nil {
+			logger.Errorf("failed to reapply %s, err: %v\n", message.Operation.OperationType, err)
+		}
+	}
+}
+
+// reconnect dials the server again in the background, backing off exponentially between attempts.
+func reconnect() chan reconnection {
+	reconnected := make(chan reconnection, 1)
+	resumeFrom := lastSequence
+	go func() {
+		delay := reconnectMinDelay
+		for attempt := 1; ; attempt++ {
+			// Jitter spreads out the reconnections of clients that lost the same server.
+			time.Sleep(delay/2 + rand.N(delay/2))
+			connection, response, err := createConnection(arguments, resumeFrom)
+			if err == nil {
+				reconnected <- reconnection{connection: connection}
+				return
+			}
+			if response != nil && response.StatusCode == http.StatusUnauthorized {
+				reconnected <- reconnection{err: errUnauthorized}
+				return
+			}
+			logger.Warnf("reconnection attempt %d failed: %v\n", attempt, err)
+			delay = min(2*delay, reconnectMaxDelay)
+		}
+	}()
+	return reconnected
+}
+
+// resume rejoins the session on a new connection and sends the changes the server hasn't acknowledged.
+func resume(connection *websocket.Conn) {
+	connected = true
+	clear(remoteUsers)
+	updateRemoteCursors()
+	sentPresence = commons.Presence{}
+	joinMessage := commons.Message{Username: username, Text: "has rejoined the session.", MessageType: commons.JoinMessage}
+	_ = connection.WriteJSON(&joinMessage)
+	pending := outbox[:0]
+	dropped := 0
+	for _, message := range outbox {
+		if message.MessageType == commons.DocSyncMessage {
+			dropped++
+			continue
+		}
+		pending = append(pending, message)
+	}
+	outbox = pending
+	for _, message := range outbox {
+		if err := connection.WriteJSON(&message); err != nil {
+			logger.Errorf("failed to replay %s, err: %v\n", message.MessageType, err)
+			return
+		}
+	}
+	ed.StatusMsg = fmt.Sprintf("reconnected, %d pending changes sent", len(outbox))
+	if dropped > 0 {
+		ed.StatusMsg += ", reload the document if it was lost"
+	}
+	ed.SetStatusBar()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"cmp"
+
+	"github.com/omesh-barhate/coderpad/commons"
+)
+
+// role is the role the server granted this client when it joined. A viewer can't change the pad.
+var role = commons.RoleEditor
+
+func setRole(granted commons.Role) {
+	role = cmp.Or(granted, commons.RoleEditor)
+	updateIndicator()
+}
+
+func isViewer() bool {
+	return role == commons.RoleViewer
+}
+
+// refuseViewer reports whether this client is a viewer, telling the user that they can't change the pad if so.
+func refuseViewer() bool {
+	if !isViewer() {
+		return false
+	}
+	ed.StatusMsg = "You joined as a viewer, the pad is read-only"
+	ed.SetStatusBar()
+	return true
+}
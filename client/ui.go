@@ -1,6 +1,10 @@
 package main
 
 import (
+	"cmp"
+	"fmt"
+	"time"
+
 	"github.com/gorilla/websocket"
 	"github.com/nsf/termbox-go"
 	"github.com/omesh-barhate/coderpad/client/editor"
@@ -19,6 +23,12 @@ func UI(connection *websocket.Conn) error {
 
 	ed = editor.NewEditor()
 	ed.SetSize(termbox.Size())
+	ed.SetText(document.Content())
+
+	unsubscribe := document.Subscribe(handleDocumentChange)
+	defer unsubscribe()
+
+	updateIndicator()
 	ed.Draw()
 
 	err = mainLoop(connection)
@@ -34,18 +44,71 @@ func mainLoop(connection *websocket.Conn) error {
 	// termboxChannel is used for sending and receiving termbox events.
 	termboxChannel := getTermboxChan()
 
-	// messageChannel is used for sending and receiving messages.
+	// messageChannel is used for sending and receiving messages. It is closed when the connection is lost,
+	// and replaced once reconnected delivers a new connection.
 	messageChannel := getMsgChan(connection)
+	var reconnected chan reconnection
+
+	// digestTicker periodically announces this client's document digest to its peers.
+	digestTicker := time.NewTicker(digestInterval)
+	defer digestTicker.Stop()
+
+	// presenceTicker throttles presence messages, see sendPresence.
+	presenceTicker := time.NewTicker(presenceInterval)
+	defer presenceTicker.Stop()
+
+	// pingTicker keeps the connection alive, and measures its health.
+	pingTicker := time.NewTicker(arguments.PingInterval)
+	defer pingTicker.Stop()
 
 	for {
 		select {
+		case <-digestTicker.C:
+			sendDigest(connection)
+		case <-presenceTicker.C:
+			sendPresence(connection)
+		case <-pingTicker.C:
+			sendPing(connection)
+			ed.Draw()
 		case event := <-termboxChannel:
 			err := handleTermboxEvent(event, connection)
 			if err != nil {
 				return err
 			}
-		case message := <-messageChannel:
+		case message, ok := <-messageChannel:
+			if !ok {
+				connected = false
+				messageChannel = nil
+				if disconnectFinal {
+					updateIndicator()
+					ed.StatusMsg = cmp.Or(disconnectReason, "disconnected") + ", press Esc to exit"
+					ed.SetStatusBar()
+					ed.Draw()
+					continue
+				}
+				reconnected = reconnect()
+				updateIndicator()
+				ed.StatusMsg = cmp.Or(disconnectReason, "lost connection") + ", reconnecting..."
+				disconnectReason = ""
+				ed.SetStatusBar()
+				ed.Draw()
+				continue
+			}
 			handleMsg(message, connection)
+		case result := <-reconnected:
+			reconnected = nil
+			if result.err != nil {
+				ed.StatusMsg = fmt.Sprintf("can't reconnect: %v", result.err)
+				ed.SetStatusBar()
+				ed.Draw()
+				continue
+			}
+			connection.Close()
+			connection = result.connection
+			messageChannel = getMsgChan(connection)
+			resume(connection)
+			updateIndicator()
+			ed.Draw()
 		}
 	}
 }
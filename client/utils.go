@@ -1,6 +1,10 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
@@ -8,9 +12,11 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/omesh-barhate/coderpad/commons"
 	"github.com/omesh-barhate/coderpad/crdt"
 	"github.com/sirupsen/logrus"
 	"github.com/sirupsen/logrus/hooks/writer"
@@ -18,41 +24,138 @@ import (
 
 type Arguments struct {
 	ServerAddress string
+	Room          string
 	UseSecure     bool
+	CAFile        string
+	CertFile      string
+	KeyFile       string
 	RequireLogin  bool
+	Token         string
+	Viewer        bool
 	FilePath      string
+	PatchPath     string
+	DiffRange     string
+	Title         string
+	Language      string
+	TabWidth      int
 	EnableDebug   bool
+	PingInterval  time.Duration
+	PongTimeout   time.Duration
 }
 
 func parseFlags() Arguments {
 	serverAddress := flag.String("server", "localhost:8080", "The network address of the server")
+	room := flag.String("room", "", "The room (pad) to join on the server; the server's default room if empty")
 	useSecure := flag.Bool("secure", false, "Enable a secure WebSocket connection (wss://)")
+	caFile := flag.String("ca", "", "CA certificates file to verify the server with, instead of the system's")
+	certFile := flag.String("cert", "", "TLS certificate file to present to the server, if it verifies clients")
+	keyFile := flag.String("key", "", "TLS private key file for -cert")
 	enableDebug := flag.Bool("debug", false, "Enable debugging mode to show more verbose logs")
 	requireLogin := flag.Bool("login", false, "Enable the login prompt for the server")
+	token := flag.String("token", "", "The access token for the room, if the server requires one")
+	viewer := flag.Bool("viewer", false, "Join as a viewer, who can watch the pad but not change it")
 	filePath := flag.String("file", "", "The file to load the coderpad content from")
+	patchPath := flag.String("patch", "", "A unified diff to apply to the coderpad content with Ctrl+U")
+	diffRange := flag.String("diff", "", "The versions to export a unified diff between with Ctrl+D, as FROM..TO; each is a checkpoint's name or number, \"empty\" or \"current\" (default: the latest checkpoint..current)")
+	title := flag.String("title", "", "Set the session title")
+	language := flag.String("language", "", "Set the session language")
+	tabWidth := flag.Int("tab-width", 0, "Set the session tab width")
+	pingInterval := flag.Duration("ping-interval", 15*time.Second, "How often to ping the server")
+	pongTimeout := flag.Duration("pong-timeout", 45*time.Second, "How long the server may go without answering a ping (or sending anything) before the connection is considered lost")
 
 	flag.Parse()
 
 	return Arguments{
 		ServerAddress: *serverAddress,
+		Room:          *room,
 		UseSecure:     *useSecure,
+		CAFile:        *caFile,
+		CertFile:      *certFile,
+		KeyFile:       *keyFile,
 		EnableDebug:   *enableDebug,
 		RequireLogin:  *requireLogin,
+		Token:         *token,
+		Viewer:        *viewer,
 		FilePath:      *filePath,
+		PatchPath:     *patchPath,
+		DiffRange:     *diffRange,
+		Title:         *title,
+		Language:      *language,
+		TabWidth:      *tabWidth,
+		PingInterval:  *pingInterval,
+		PongTimeout:   *pongTimeout,
 	}
 }
 
-func createConnection(arguments Arguments) (*websocket.Conn, *http.Response, error) {
+// sessionSecret identifies this client's site to the server, so it keeps its site ID when it reconnects.
+var sessionSecret = newSessionSecret()
+
+func newSessionSecret() string {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(secret)
+}
+
+// createConnection dials the server. If resumeFrom is set, the server only sends the changes made after it, if it still can.
+func createConnection(arguments Arguments, resumeFrom uint64) (*websocket.Conn, *http.Response, error) {
+	path := "/"
+	if arguments.Room != "" {
+		path = "/pad/" + arguments.Room
+	}
 	var wsURL url.URL
 	if arguments.UseSecure {
-		wsURL = url.URL{Scheme: "wss", Host: arguments.ServerAddress, Path: "/"}
+		wsURL = url.URL{Scheme: "wss", Host: arguments.ServerAddress, Path: path}
 	} else {
-		wsURL = url.URL{Scheme: "ws", Host: arguments.ServerAddress, Path: "/"}
+		wsURL = url.URL{Scheme: "ws", Host: arguments.ServerAddress, Path: path}
+	}
+	query := url.Values{}
+	if arguments.Viewer {
+		query.Set("role", string(commons.RoleViewer))
 	}
+	if resumeFrom > 0 {
+		query.Set("resume", strconv.FormatUint(resumeFrom, 10))
+	}
+	wsURL.RawQuery = query.Encode()
 	dialer := websocket.Dialer{
 		HandshakeTimeout: 2 * time.Minute,
 	}
-	return dialer.Dial(wsURL.String(), nil)
+	if arguments.UseSecure {
+		tlsConfig, err := clientTLSConfig(arguments)
+		if err != nil {
+			return nil, nil, err
+		}
+		dialer.TLSClientConfig = tlsConfig
+	}
+	header := http.Header{}
+	if arguments.Token != "" {
+		header.Set("Authorization", "Bearer "+arguments.Token)
+	}
+	header.Set(commons.SessionHeader, sessionSecret)
+	return dialer.Dial(wsURL.String(), header)
+}
+
+func clientTLSConfig(arguments Arguments) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if arguments.CAFile != "" {
+		content, err := os.ReadFile(arguments.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no certificates found in %s", arguments.CAFile)
+		}
+	}
+	if arguments.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(arguments.CertFile, arguments.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
 }
 
 func ensureDirectoryExists(directoryPath string) (bool, error) {
@@ -141,3 +244,18 @@ func printDocument(document crdt.Document) {
 		}
 	}
 }
+
+// checkDocument validates the document structure in debug mode, repairing it and logging every violation found.
+func checkDocument() {
+	if !arguments.EnableDebug {
+		return
+	}
+	violations := document.Repair("repair")
+	for _, violation := range violations {
+		logger.Warnf("document violation: %v", violation)
+	}
+	if len(violations) > 0 {
+		ed.StatusMsg = fmt.Sprintf("repaired %d document violations", len(violations))
+		ed.SetStatusBar()
+	}
+}
@@ -6,19 +6,114 @@ import (
 )
 
 type Message struct {
-	Username    string        `json:"username"`
-	Text        string        `json:"text"`
-	MessageType MessageType   `json:"type"`
-	ClientID    uuid.UUID     `json:"ID"`
-	Operation   Operation     `json:"operation"`
-	Document    crdt.Document `json:"document"`
+	Username    string             `json:"username"`
+	Text        string             `json:"text"`
+	MessageType MessageType        `json:"type"`
+	ClientID    uuid.UUID          `json:"ID"`
+	Operation   Operation          `json:"operation"`
+	Document    crdt.Document      `json:"document"`
+	Digest      *crdt.Digest       `json:"digest,omitempty"`
+	MetadataOp  *crdt.MapOperation `json:"metadataOp,omitempty"`
+	Metadata    *crdt.Map          `json:"metadata,omitempty"`
+	// Role is the role granted to the client, sent with its SiteIDMessage.
+	Role     Role      `json:"role,omitempty"`
+	Presence *Presence `json:"presence,omitempty"`
+	// Sequence numbers the changes to a room (operations, docSyncs and metadata) in the order the server applied them.
+	// A room state sent by the server carries the sequence number of the last change it includes.
+	Sequence uint64 `json:"seq,omitempty"`
+	// Clock is, in a SiteIDMessage, the highest clock value the site already used in the room's document.
+	Clock int `json:"clock,omitempty"`
+	// Error is carried by ErrorMessages.
+	Error *Error `json:"error,omitempty"`
+	// ChangeID numbers the changes a client sends. The server echoes it in the AckMessage or ErrorMessage answering
+	// the change, and in a room state sent to resync the client, the ID of the latest change it answered.
+	// It acknowledges every earlier change too, as the server answers a client's changes in order.
+	ChangeID uint64 `json:"change,omitempty"`
 }
 
+// SessionHeader carries a secret that the client picks, and sends every time it connects.
+// The server issues the same site ID to every connection with the same secret.
+const SessionHeader = "X-Coderpad-Session"
+
+// Close codes the server uses, besides the standard ones, when it disconnects a client that must not reconnect.
+const (
+	CloseKicked     = 4001
+	CloseRoomClosed = 4002
+)
+
 type MessageType string
 
 const (
 	DocSyncMessage MessageType = "docSync"
-	DocReqMessage  MessageType = "docReq"
 	SiteIDMessage  MessageType = "SiteID"
 	JoinMessage    MessageType = "join"
+
+	// DigestMessage periodically announces the digest of the sender's document.
+	DigestMessage MessageType = "digest"
+	// StateReqMessage asks the server for the room state, which it sends back to the sender alone as a docSync.
+	StateReqMessage MessageType = "stateReq"
+	// MetadataMessage carries a MetadataOp changing one of the session metadata keys.
+	MetadataMessage MessageType = "metadata"
+	// PresenceMessage carries the sender's Presence. The server sends the latest one of every client to joiners.
+	PresenceMessage MessageType = "presence"
+	// LeaveMessage is sent by the server when the client whose ID is in ClientID disconnects.
+	LeaveMessage MessageType = "leave"
+	// AckMessage tells a client that the server applied its change ChangeID, as Sequence.
+	AckMessage MessageType = "ack"
+	// ErrorMessage tells a client that the server rejected or throttled its messages, and why, in Error.
+	ErrorMessage MessageType = "error"
+)
+
+// Error describes a problem with the messages a client sent.
+type Error struct {
+	Code ErrorCode `json:"code"`
+	Text string    `json:"text"`
+}
+
+type ErrorCode string
+
+const (
+	// ErrorRateLimited means the client sends messages faster than the server allows; they are delayed.
+	ErrorRateLimited ErrorCode = "rate_limited"
+	// ErrorMessageTooLarge means the message is larger than the server allows; the server closes the connection after it.
+	ErrorMessageTooLarge ErrorCode = "message_too_large"
+	// The following errors mean the message was dropped.
+
+	// ErrorMalformedMessage means the message isn't valid JSON.
+	ErrorMalformedMessage ErrorCode = "malformed_message"
+	// ErrorUnknownType means the message's type doesn't exist, or is only sent by the server.
+	ErrorUnknownType ErrorCode = "unknown_type"
+	// ErrorInvalidMessage means the message lacks fields its type requires, or has invalid ones.
+	ErrorInvalidMessage ErrorCode = "invalid_message"
+	// ErrorInvalidOperation means an operation message's operation is invalid.
+	ErrorInvalidOperation ErrorCode = "invalid_operation"
+	// ErrorForbidden means the client's role doesn't allow the message, e.g. a viewer's edit.
+	ErrorForbidden ErrorCode = "forbidden"
+)
+
+// Presence is where a user is working in the pad. Positions are crdt anchors, so they stay put as the document changes.
+type Presence struct {
+	Cursor string `json:"cursor"`
+	// Selection is the other end of the user's selection, or empty if nothing is selected.
+	Selection string `json:"selection,omitempty"`
+	// Color is the index of the user's colour in the clients' palette.
+	Color int `json:"color"`
+}
+
+// Session metadata keys, stored in a crdt.Map shared by all clients.
+const (
+	MetadataTitle    = "title"
+	MetadataLanguage = "language"
+	MetadataTabWidth = "tabWidth"
+	MetadataReadOnly = "readOnly"
+)
+
+// Role is what a client is allowed to do in a room.
+type Role string
+
+const (
+	// RoleEditor can change the pad.
+	RoleEditor Role = "editor"
+	// RoleViewer can only watch: the server drops its operations, docSyncs and metadata changes.
+	RoleViewer Role = "viewer"
 )
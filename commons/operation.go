@@ -1,9 +1,40 @@
 package commons
 
+import (
+	"fmt"
+
+	"github.com/omesh-barhate/coderpad/crdt"
+)
+
 type Operation struct {
 	OperationType string `json:"type"`
 
 	Position int `json:"position"`
 
 	Value string `json:"value"`
+
+	// Character is the inserted or deleted character, so replicas can integrate the operation by ID.
+	// Operations without it are applied by position.
+	Character *crdt.Character `json:"character,omitempty"`
+}
+
+// Apply applies an operation received from another replica to document.
+func (operation Operation) Apply(document *crdt.SyncedDocument, author string) error {
+	switch operation.OperationType {
+	case "insert":
+		if operation.Character != nil {
+			_, err := document.IntegrateInsert(*operation.Character, author)
+			return err
+		}
+		_, err := document.Insert(operation.Position, operation.Value, author)
+		return err
+	case "delete":
+		if operation.Character != nil {
+			document.IntegrateDelete(operation.Character.ID, author)
+			return nil
+		}
+		document.Delete(operation.Position, author)
+		return nil
+	}
+	return fmt.Errorf("unknown operation type %q", operation.OperationType)
 }
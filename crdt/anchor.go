@@ -0,0 +1,36 @@
+package crdt
+
+// An anchor identifies a position in a document by the ID of the character before it
+// (StartCharacter's ID at the beginning of the document), so it keeps pointing at the same spot
+// while characters are inserted and deleted around it, on every replica.
+
+// AnchorAt returns the anchor of the position after the given number of visible characters.
+func AnchorAt(document Document, position int) string {
+	anchor := StartCharacter.ID
+	visibleCount := 0
+	for _, character := range document.Characters {
+		if visibleCount == position {
+			break
+		}
+		if character.Visible {
+			anchor = character.ID
+			visibleCount++
+		}
+	}
+	return anchor
+}
+
+// AnchorPosition returns the number of visible characters before an anchor, or -1 if the document doesn't contain it.
+// An anchor on a deleted character stays where the character was.
+func AnchorPosition(document Document, anchor string) int {
+	visibleCount := 0
+	for _, character := range document.Characters {
+		if character.Visible {
+			visibleCount++
+		}
+		if character.ID == anchor {
+			return visibleCount
+		}
+	}
+	return -1
+}
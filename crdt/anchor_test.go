@@ -0,0 +1,39 @@
+package crdt
+
+import "testing"
+
+func TestAnchor(t *testing.T) {
+	document := NewSyncedDocument(New())
+	for i, value := range []string{"a", "b", "c"} {
+		if _, err := document.Insert(i+1, value, "alice"); err != nil {
+			t.Fatalf("error: %v\n", err)
+		}
+	}
+	// Anchors between "a" and "b", at the end, at the beginning, and past the end.
+	middle, end, beginning, past := document.Anchor(1), document.Anchor(3), document.Anchor(0), document.Anchor(10)
+	if beginning != StartCharacter.ID || past != end {
+		t.Errorf("anchor mismatch; got = %v and %v, expected = %v and %v\n", beginning, past, StartCharacter.ID, end)
+	}
+
+	if _, err := document.Insert(1, "x", "bob"); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if _, ok := document.Delete(2, "bob"); !ok {
+		t.Fatalf("delete of existing character reported no change")
+	}
+	// "xbc": the anchored "a" was deleted, so the anchor stays after "x".
+	tests := []struct {
+		anchor   string
+		expected int
+	}{
+		{middle, 1},
+		{end, 3},
+		{beginning, 0},
+		{"missing", -1},
+	}
+	for _, test := range tests {
+		if got := document.AnchorPosition(test.anchor); got != test.expected {
+			t.Errorf("(%s) position mismatch; got = %v, expected = %v\n", test.anchor, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package crdt
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrMalformedPatch = errors.New("malformed patch")
+	ErrPatchConflict  = errors.New("patch does not apply")
+)
+
+// Edit is a single character insertion or deletion at a 1-based visible position.
+// Edits returned by Edits are meant to be applied in order, each one against the result of the previous.
+type Edit struct {
+	Type     ChangeType
+	Position int
+	Value    string
+}
+
+// Lines splits content into lines, keeping the trailing newline on each of them.
+func Lines(content string) []string {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// hunk describes a run of lines replaced between two texts.
+type hunk struct {
+	fromStart, fromEnd int
+	toStart, toEnd     int
+}
+
+// diffLines computes the changed runs between two sets of lines, with Myers' algorithm in linear space,
+// so large texts can be compared without a table of every pair of lines.
+func diffLines(from, to []string) []hunk {
+	diff := lineDiff{from: from, to: to, removed: make([]bool, len(from)), added: make([]bool, len(to)), effort: diffEffort}
+	diff.compare(0, len(from), 0, len(to))
+	var hunks []hunk
+	i, j := 0, 0
+	for i < len(from) || j < len(to) {
+		if i < len(from) && j < len(to) && !diff.removed[i] && !diff.added[j] {
+			i++
+			j++
+			continue
+		}
+		current := hunk{fromStart: i, toStart: j}
+		for i < len(from) && diff.removed[i] {
+			i++
+		}
+		for j < len(to) && diff.added[j] {
+			j++
+		}
+		current.fromEnd, current.toEnd = i, j
+		hunks = append(hunks, current)
+	}
+	return hunks
+}
+
+// diffEffort bounds the steps a diff takes, so that comparing large, very different texts doesn't take minutes.
+// Once they are spent, the lines left are diffed as entirely replaced, which is correct but not minimal.
+const diffEffort = 1 << 24
+
+// lineDiff marks the lines removed from from and added in to.
+type lineDiff struct {
+	from, to       []string
+	removed, added []bool
+	// effort is the number of steps left.
+	effort int
+}
+
+// compare marks the differences between from[fromStart:fromEnd] and to[toStart:toEnd].
+func (diff *lineDiff) compare(fromStart, fromEnd, toStart, toEnd int) {
+	for fromStart < fromEnd && toStart < toEnd && diff.from[fromStart] == diff.to[toStart] {
+		fromStart++
+		toStart++
+	}
+	for fromStart < fromEnd && toStart < toEnd && diff.from[fromEnd-1] == diff.to[toEnd-1] {
+		fromEnd--
+		toEnd--
+	}
+	if fromStart == fromEnd || toStart == toEnd {
+		for i := fromStart; i < fromEnd; i++ {
+			diff.removed[i] = true
+		}
+		for j := toStart; j < toEnd; j++ {
+			diff.added[j] = true
+		}
+		return
+	}
+	x, y, found := diff.bisect(fromStart, fromEnd, toStart, toEnd)
+	if !found {
+		// Every line is removed, then every line added.
+		x, y = fromEnd, toStart
+	}
+	diff.compare(fromStart, x, toStart, y)
+	diff.compare(x, fromEnd, y, toEnd)
+}
+
+// bisect finds where the forward and backward searches for the shortest edit script meet,
+// which splits the comparison in two halves that are compared independently.
+// It reports false if the ranges have no line in common, or if the diff's effort is spent before it finds out.
+func (diff *lineDiff) bisect(fromStart, fromEnd, toStart, toEnd int) (int, int, bool) {
+	from, to := diff.from[fromStart:fromEnd], diff.to[toStart:toEnd]
+	n, m := len(from), len(to)
+	maxD := (n + m + 1) / 2
+	offset := maxD + 1
+	forward, backward := make([]int, 2*offset+1), make([]int, 2*offset+1)
+	for i := range forward {
+		forward[i], backward[i] = -1, -1
+	}
+	forward[offset+1], backward[offset+1] = 0, 0
+	delta := n - m
+	// If delta is odd, the forward search is the first to overlap the backward one, else the backward search is.
+	front := delta%2 != 0
+	forwardStart, forwardEnd, backwardStart, backwardEnd := 0, 0, 0, 0
+	for d := 0; d < maxD && diff.effort > 0; d++ {
+		for k := -d + forwardStart; k <= d-forwardEnd; k += 2 {
+			index := offset + k
+			var x int
+			if k == -d || (k != d && forward[index-1] < forward[index+1]) {
+				x = forward[index+1]
+			} else {
+				x = forward[index-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && from[x] == to[y] {
+				x++
+				y++
+				diff.effort--
+			}
+			diff.effort--
+			forward[index] = x
+			switch {
+			case x > n:
+				forwardEnd += 2
+			case y > m:
+				forwardStart += 2
+			case front:
+				if other := offset + delta - k; other >= 0 && other < len(backward) && backward[other] != -1 && x >= n-backward[other] {
+					return fromStart + x, toStart + y, true
+				}
+			}
+		}
+		for k := -d + backwardStart; k <= d-backwardEnd; k += 2 {
+			index := offset + k
+			var x int
+			if k == -d || (k != d && backward[index-1] < backward[index+1]) {
+				x = backward[index+1]
+			} else {
+				x = backward[index-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && from[n-x-1] == to[m-y-1] {
+				x++
+				y++
+				diff.effort--
+			}
+			diff.effort--
+			backward[index] = x
+			switch {
+			case x > n:
+				backwardEnd += 2
+			case y > m:
+				backwardStart += 2
+			case !front:
+				if other := offset + delta - k; other >= 0 && other < len(forward) && forward[other] != -1 {
+					forwardX := forward[other]
+					if forwardX >= n-x {
+						return fromStart + forwardX, toStart + forwardX - (other - offset), true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// Edits returns the character edits that turn from into to.
+// Lines are compared first, and only the differing middle of each changed run of lines is edited,
+// so text around the changes (and concurrent edits to it) is left alone.
+func Edits(from, to string) []Edit {
+	fromLines, toLines := Lines(from), Lines(to)
+	var edits []Edit
+	// offset is the number of characters before the current hunk in the partially edited text.
+	offset, fromLine := 0, 0
+	for _, h := range diffLines(fromLines, toLines) {
+		for ; fromLine < h.fromStart; fromLine++ {
+			offset += len([]rune(fromLines[fromLine]))
+		}
+		removed := []rune(strings.Join(fromLines[h.fromStart:h.fromEnd], ""))
+		added := []rune(strings.Join(toLines[h.toStart:h.toEnd], ""))
+		prefix, suffix := commonAffixes(removed, added)
+		for i := prefix; i < len(removed)-suffix; i++ {
+			edits = append(edits, Edit{Type: ChangeDelete, Position: offset + prefix + 1})
+		}
+		for i, r := range added[prefix : len(added)-suffix] {
+			edits = append(edits, Edit{Type: ChangeInsert, Position: offset + prefix + i + 1, Value: string(r)})
+		}
+		offset += len(added)
+		fromLine = h.fromEnd
+	}
+	return edits
+}
+
+func commonAffixes(a, b []rune) (int, int) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	return prefix, suffix
+}
+
+const diffContext = 3
+
+// UnifiedDiff returns the changes between from and to in the unified diff format, or "" if there are none.
+func UnifiedDiff(fromName, toName, from, to string) string {
+	fromLines, toLines := Lines(from), Lines(to)
+	hunks := diffLines(fromLines, toLines)
+	if len(hunks) == 0 {
+		return ""
+	}
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "--- %s\n+++ %s\n", fromName, toName)
+	for start := 0; start < len(hunks); {
+		// Merge hunks whose context would overlap.
+		end := start + 1
+		for end < len(hunks) && hunks[end].fromStart-hunks[end-1].fromEnd <= 2*diffContext {
+			end++
+		}
+		first, last := hunks[start], hunks[end-1]
+		fromStart := max(first.fromStart-diffContext, 0)
+		fromEnd := min(last.fromEnd+diffContext, len(fromLines))
+		toStart := first.toStart - (first.fromStart - fromStart)
+		toEnd := last.toEnd + (fromEnd - last.fromEnd)
+		fmt.Fprintf(&builder, "@@ -%s +%s @@\n", hunkRange(fromStart, fromEnd-fromStart), hunkRange(toStart, toEnd-toStart))
+		line := fromStart
+		for _, h := range hunks[start:end] {
+			for ; line < h.fromStart; line++ {
+				writeDiffLine(&builder, ' ', fromLines[line])
+			}
+			for _, removed := range fromLines[h.fromStart:h.fromEnd] {
+				writeDiffLine(&builder, '-', removed)
+			}
+			for _, added := range toLines[h.toStart:h.toEnd] {
+				writeDiffLine(&builder, '+', added)
+			}
+			line = h.fromEnd
+		}
+		for ; line < fromEnd; line++ {
+			writeDiffLine(&builder, ' ', fromLines[line])
+		}
+		start = end
+	}
+	return builder.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return strconv.Itoa(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func writeDiffLine(builder *strings.Builder, prefix byte, line string) {
+	builder.WriteByte(prefix)
+	builder.WriteString(line)
+	if !strings.HasSuffix(line, "\n") {
+		builder.WriteString("\n\\ No newline at end of file\n")
+	}
+}
+
+// ApplyPatch applies a unified diff to content and returns the result.
+// A hunk whose context has moved is applied at the nearest position where its original lines match.
+func ApplyPatch(content, patch string) (string, error) {
+	lines := Lines(content)
+	var result []string
+	// consumed is the number of lines of content already copied or replaced.
+	consumed := 0
+	patchLines := Lines(patch)
+	for i := 0; i < len(patchLines); {
+		if !strings.HasPrefix(patchLines[i], "@@") {
+			i++
+			continue
+		}
+		fromStart, fromCount, toCount, err := parseHunkHeader(patchLines[i])
+		if err != nil {
+			return content, err
+		}
+		i++
+		var removed, added []string
+		var previous byte
+		for ; i < len(patchLines) && (len(removed) < fromCount || len(added) < toCount || strings.HasPrefix(patchLines[i], "\\")); i++ {
+			body := patchLines[i]
+			if body == "\n" {
+				body = " \n"
+			}
+			switch body[0] {
+			case '\\':
+				// The previous line has no trailing newline.
+				if previous != ' ' && previous != '-' && previous != '+' {
+					return content, fmt.Errorf("%w: misplaced %q", ErrMalformedPatch, strings.TrimSuffix(body, "\n"))
+				}
+				if previous != '+' {
+					removed[len(removed)-1] = strings.TrimSuffix(removed[len(removed)-1], "\n")
+				}
+				if previous != '-' {
+					added[len(added)-1] = strings.TrimSuffix(added[len(added)-1], "\n")
+				}
+			case ' ':
+				removed = append(removed, body[1:])
+				added = append(added, body[1:])
+			case '-':
+				removed = append(removed, body[1:])
+			case '+':
+				added = append(added, body[1:])
+			default:
+				return content, fmt.Errorf("%w: unexpected line %q", ErrMalformedPatch, strings.TrimSuffix(body, "\n"))
+			}
+			previous = body[0]
+		}
+		if len(removed) != fromCount || len(added) != toCount {
+			return content, fmt.Errorf("%w: truncated hunk", ErrMalformedPatch)
+		}
+		at, ok := locate(lines, removed, fromStart, consumed)
+		if !ok {
+			return content, fmt.Errorf("%w: hunk at line %d", ErrPatchConflict, fromStart+1)
+		}
+		result = append(result, lines[consumed:at]...)
+		result = append(result, added...)
+		consumed = at + len(removed)
+	}
+	result = append(result, lines[consumed:]...)
+	return strings.Join(result, ""), nil
+}
+
+// parseHunkHeader parses "@@ -start,count +start,count @@" into the 0-based start line and both line counts.
+func parseHunkHeader(header string) (int, int, int, error) {
+	malformed := fmt.Errorf("%w: bad hunk header %q", ErrMalformedPatch, strings.TrimSpace(header))
+	fields := strings.Fields(header)
+	if len(fields) < 3 || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return 0, 0, 0, malformed
+	}
+	fromStart, fromCount, err := parseHunkRange(fields[1][1:])
+	if err != nil {
+		return 0, 0, 0, malformed
+	}
+	_, toCount, err := parseHunkRange(fields[2][1:])
+	if err != nil {
+		return 0, 0, 0, malformed
+	}
+	if fromCount == 0 {
+		// An empty range names the line before the change.
+		return fromStart, fromCount, toCount, nil
+	}
+	return max(fromStart-1, 0), fromCount, toCount, nil
+}
+
+func parseHunkRange(value string) (int, int, error) {
+	parts := strings.SplitN(value, ",", 2)
+	start, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(parts) == 1 {
+		return start, 1, nil
+	}
+	count, err := strconv.Atoi(parts[1])
+	return start, count, err
+}
+
+// locate finds where the lines in want start in lines, searching outwards from expected but never before minimum.
+func locate(lines, want []string, expected, minimum int) (int, bool) {
+	matches := func(at int) bool {
+		if at < minimum || at+len(want) > len(lines) {
+			return false
+		}
+		for i, line := range want {
+			if lines[at+i] != line {
+				return false
+			}
+		}
+		return true
+	}
+	for distance := 0; distance <= len(lines); distance++ {
+		if matches(expected - distance) {
+			return expected - distance, true
+		}
+		if matches(expected + distance) {
+			return expected + distance, true
+		}
+	}
+	return 0, false
+}
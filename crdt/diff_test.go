@@ -0,0 +1,125 @@
+package crdt
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var diffTests = []struct {
+	description string
+	from        string
+	to          string
+}{
+	{description: "identical", from: "a\nb\n", to: "a\nb\n"},
+	{description: "from empty", from: "", to: "a\nb\n"},
+	{description: "to empty", from: "a\nb\n", to: ""},
+	{description: "changed line", from: "a\nb\nc\n", to: "a\nx\nc\n"},
+	{description: "no trailing newline", from: "a\nb", to: "a\nb\nc"},
+	{description: "distant changes", from: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", to: "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n"},
+	{description: "inline edit", from: "func main() {\n}\n", to: "func main() {\n\tfmt.Println(\"hi\")\n}\n"},
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	got := UnifiedDiff("a.go", "b.go", "a\nb\nc\nd\n", "a\nx\nc\nd\ne")
+	want := "--- a.go\n+++ b.go\n@@ -1,4 +1,5 @@\n a\n-b\n+x\n c\n d\n+e\n\\ No newline at end of file\n"
+	if got != want {
+		t.Errorf("diff mismatch; diff = %v\n", cmp.Diff(got, want))
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	for _, tc := range diffTests {
+		patch := UnifiedDiff("from", "to", tc.from, tc.to)
+		got, err := ApplyPatch(tc.from, patch)
+		if err != nil {
+			t.Errorf("(%s) error: %v\n", tc.description, err)
+		}
+		if got != tc.to {
+			t.Errorf("(%s) content mismatch; diff = %v\n", tc.description, cmp.Diff(got, tc.to))
+		}
+	}
+}
+
+func TestApplyPatch_LargeTexts(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	text := func() string {
+		var builder strings.Builder
+		for i := 0; i < 20000; i++ {
+			builder.WriteString(string(rune('a' + random.Intn(3))))
+			builder.WriteString("\n")
+		}
+		return builder.String()
+	}
+	// The texts differ everywhere, which spends the diff's effort: the rest is diffed as replaced, rather than slowly.
+	from, to := text(), text()
+	got, err := ApplyPatch(from, UnifiedDiff("from", "to", from, to))
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if got != to {
+		t.Errorf("content mismatch; got %d bytes, expected %d\n", len(got), len(to))
+	}
+}
+
+func TestApplyPatch_Offset(t *testing.T) {
+	patch := UnifiedDiff("from", "to", "a\nb\nc\n", "a\nB\nc\n")
+	got, err := ApplyPatch("header\na\nb\nc\n", patch)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if want := "header\na\nB\nc\n"; got != want {
+		t.Errorf("content mismatch; diff = %v\n", cmp.Diff(got, want))
+	}
+	if _, err := ApplyPatch("a\nz\nc\n", patch); !errors.Is(err, ErrPatchConflict) {
+		t.Errorf("expected a conflict; got %v\n", err)
+	}
+	if _, err := ApplyPatch("a\n", "@@ -1,2 +1,2 @@\n a\n"); !errors.Is(err, ErrMalformedPatch) {
+		t.Errorf("expected a malformed patch error; got %v\n", err)
+	}
+}
+
+// applyEdits applies edits to a document, in order.
+func applyEdits(t *testing.T, document *SyncedDocument, edits []Edit) {
+	t.Helper()
+	for _, edit := range edits {
+		switch edit.Type {
+		case ChangeInsert:
+			if _, err := document.Insert(edit.Position, edit.Value, "test"); err != nil {
+				t.Fatalf("error: %v\n", err)
+			}
+		case ChangeDelete:
+			if _, ok := document.Delete(edit.Position, "test"); !ok {
+				t.Fatalf("delete out of range at %d\n", edit.Position)
+			}
+		}
+	}
+}
+
+func TestEdits(t *testing.T) {
+	for _, tc := range diffTests {
+		document := NewSyncedDocument(New())
+		applyEdits(t, document, Edits("", tc.from))
+		applyEdits(t, document, Edits(tc.from, tc.to))
+		if got := document.Content(); got != tc.to {
+			t.Errorf("(%s) content mismatch; diff = %v\n", tc.description, cmp.Diff(got, tc.to))
+		}
+	}
+}
+
+func TestEdits_OnlyTheDifference(t *testing.T) {
+	document := NewSyncedDocument(New())
+	applyEdits(t, document, Edits("", "hello world"))
+	changes := 0
+	document.Subscribe(func(change Change) { changes++ })
+	applyEdits(t, document, Edits("hello world", "hello there world"))
+	if got, want := document.Content(), "hello there world"; got != want {
+		t.Errorf("content mismatch; got = %v, expected = %v\n", got, want)
+	}
+	if changes != len("there ") {
+		t.Errorf("expected only the middle to change; got %v changes\n", changes)
+	}
+}
@@ -0,0 +1,23 @@
+package crdt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Digest is a digest of a document's CRDT state: its characters' IDs, visibility and values, in order.
+// Replicas that integrated the same operations have the same digest. Replicas with the same text but different
+// characters don't, as operations referring to the characters one of them lacks would fail there.
+type Digest struct {
+	Hash string `json:"hash"`
+}
+
+func NewDigest(document Document) Digest {
+	hash := sha256.New()
+	for _, character := range document.Characters {
+		// Lengths delimit the fields, so no two different states are written the same way.
+		fmt.Fprintf(hash, "%d:%s%t%d:%s", len(character.ID), character.ID, character.Visible, len(character.Value), character.Value)
+	}
+	return Digest{Hash: hex.EncodeToString(hash.Sum(nil))[:16]}
+}
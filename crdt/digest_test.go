@@ -0,0 +1,29 @@
+package crdt
+
+import "testing"
+
+func TestNewDigest(t *testing.T) {
+	document := func(ids ...string) *SyncedDocument {
+		synced := NewSyncedDocument(New())
+		for i, id := range ids {
+			if _, err := synced.InsertWithID(i+1, string(rune('a'+i)), id, "alice"); err != nil {
+				t.Fatalf("error: %v\n", err)
+			}
+		}
+		return synced
+	}
+	local := NewDigest(document("1.1", "1.2").Snapshot())
+	if got := NewDigest(document("1.1", "1.2").Snapshot()); got != local {
+		t.Errorf("digest mismatch for the same state; got = %v, expected = %v\n", got, local)
+	}
+	// The same text made of other characters is a different state.
+	if got := NewDigest(document("1.1", "2.1").Snapshot()); got == local {
+		t.Errorf("digests of different characters match: %v\n", got)
+	}
+	// So is a deleted character, even if another was inserted in its place.
+	deleted := document("1.1", "1.2", "1.3")
+	deleted.IntegrateDelete("1.3", "alice")
+	if got := NewDigest(deleted.Snapshot()); got == local {
+		t.Errorf("digests with and without a deleted character match: %v\n", got)
+	}
+}
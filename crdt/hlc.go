@@ -0,0 +1,80 @@
+package crdt
+
+import (
+	"sync"
+	"time"
+)
+
+// Timestamp is a hybrid logical clock reading: wall-clock milliseconds, a logical counter
+// that orders events within the same millisecond, and the site that produced it to break ties.
+// Timestamps from different sites are never equal, so they also serve as unique tags.
+type Timestamp struct {
+	Wall    int64 `json:"wall"`
+	Logical int   `json:"logical"`
+	Site    int   `json:"site"`
+}
+
+func (t Timestamp) Before(other Timestamp) bool {
+	if t.Wall != other.Wall {
+		return t.Wall < other.Wall
+	}
+	if t.Logical != other.Logical {
+		return t.Logical < other.Logical
+	}
+	return t.Site < other.Site
+}
+
+func (t Timestamp) IsZero() bool {
+	return t == Timestamp{}
+}
+
+// Clock is a hybrid logical clock. It follows physical time when it can,
+// but never goes backwards and always moves past the timestamps it has seen.
+type Clock struct {
+	mutex sync.Mutex
+	last  Timestamp
+	site  int
+	now   func() time.Time
+}
+
+func NewClock(site int) *Clock {
+	return &Clock{site: site, now: time.Now}
+}
+
+func (c *Clock) SetSite(site int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.site = site
+}
+
+// Now returns a timestamp for a local event.
+func (c *Clock) Now() Timestamp {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	wall := c.now().UnixMilli()
+	if wall > c.last.Wall {
+		c.last = Timestamp{Wall: wall}
+	} else {
+		c.last.Logical++
+	}
+	c.last.Site = c.site
+	return c.last
+}
+
+// Update advances the clock past a timestamp received from another site.
+func (c *Clock) Update(remote Timestamp) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	wall := c.now().UnixMilli()
+	switch {
+	case wall > c.last.Wall && wall > remote.Wall:
+		c.last = Timestamp{Wall: wall}
+	case remote.Wall > c.last.Wall:
+		c.last = Timestamp{Wall: remote.Wall, Logical: remote.Logical + 1}
+	case remote.Wall == c.last.Wall:
+		c.last.Logical = max(c.last.Logical, remote.Logical) + 1
+	default:
+		c.last.Logical++
+	}
+	c.last.Site = c.site
+}
@@ -0,0 +1,180 @@
+package crdt
+
+import "sort"
+
+// Register is a last-writer-wins register: concurrent writes converge to the one with the latest timestamp.
+type Register struct {
+	Value     string    `json:"value"`
+	Timestamp Timestamp `json:"timestamp"`
+}
+
+// Set stores value if timestamp is later than the current one, and reports whether it did.
+func (r *Register) Set(value string, timestamp Timestamp) bool {
+	if !r.Timestamp.Before(timestamp) {
+		return false
+	}
+	r.Value = value
+	r.Timestamp = timestamp
+	return true
+}
+
+func (r *Register) Merge(other Register) bool {
+	return r.Set(other.Value, other.Timestamp)
+}
+
+// MapEntry is the state of one key of a Map.
+// Every write to the key is kept as its own register until a later operation observes and removes it.
+// The key is present while any register is live, and its value is that of the latest one.
+type MapEntry struct {
+	Live    []Register  `json:"live,omitempty"`
+	Removed []Timestamp `json:"removed,omitempty"`
+}
+
+// MapOperation describes a change to a Map, so it can be sent to and applied by other replicas.
+// Both puts and removals remove the Observed writes; a concurrent put is not observed, so it survives a removal.
+type MapOperation struct {
+	Key       string      `json:"key"`
+	Value     string      `json:"value,omitempty"`
+	Timestamp Timestamp   `json:"timestamp"`
+	Remove    bool        `json:"remove,omitempty"`
+	Observed  []Timestamp `json:"observed,omitempty"`
+}
+
+// Map is an observed-remove map of last-writer-wins registers.
+// Like Document, it is not safe for concurrent use.
+type Map struct {
+	Entries map[string]*MapEntry `json:"entries"`
+}
+
+func NewMap() *Map {
+	return &Map{Entries: make(map[string]*MapEntry)}
+}
+
+func (m *Map) Get(key string) (string, bool) {
+	entry, ok := m.Entries[key]
+	if !ok || len(entry.Live) == 0 {
+		return "", false
+	}
+	var latest Register
+	for _, register := range entry.Live {
+		latest.Merge(register)
+	}
+	return latest.Value, true
+}
+
+func (m *Map) Keys() []string {
+	keys := make([]string, 0, len(m.Entries))
+	for key, entry := range m.Entries {
+		if len(entry.Live) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Put sets key to value locally and returns the operation to send to other replicas.
+func (m *Map) Put(key, value string, timestamp Timestamp) MapOperation {
+	operation := MapOperation{Key: key, Value: value, Timestamp: timestamp, Observed: m.observed(key)}
+	m.Apply(operation)
+	return operation
+}
+
+// Remove removes key locally and returns the operation to send to other replicas.
+func (m *Map) Remove(key string, timestamp Timestamp) MapOperation {
+	operation := MapOperation{Key: key, Timestamp: timestamp, Remove: true, Observed: m.observed(key)}
+	m.Apply(operation)
+	return operation
+}
+
+func (m *Map) observed(key string) []Timestamp {
+	entry, ok := m.Entries[key]
+	if !ok {
+		return nil
+	}
+	observed := make([]Timestamp, len(entry.Live))
+	for i, register := range entry.Live {
+		observed[i] = register.Timestamp
+	}
+	return observed
+}
+
+// Apply applies a local or remote operation and reports whether the visible state of the map changed.
+// Applying the same operation twice has no further effect.
+func (m *Map) Apply(operation MapOperation) bool {
+	value, present := m.Get(operation.Key)
+	entry := m.entry(operation.Key)
+	for _, timestamp := range operation.Observed {
+		entry.remove(timestamp)
+	}
+	if !operation.Remove {
+		entry.add(Register{Value: operation.Value, Timestamp: operation.Timestamp})
+	}
+	newValue, newPresent := m.Get(operation.Key)
+	return value != newValue || present != newPresent
+}
+
+// Merge merges the full state of another replica into m, e.g. when joining a session.
+func (m *Map) Merge(other *Map) {
+	for key, otherEntry := range other.Entries {
+		entry := m.entry(key)
+		for _, timestamp := range otherEntry.Removed {
+			entry.remove(timestamp)
+		}
+		for _, register := range otherEntry.Live {
+			entry.add(register)
+		}
+	}
+}
+
+// Clone returns a deep copy of m.
+func (m *Map) Clone() *Map {
+	clone := NewMap()
+	clone.Merge(m)
+	return clone
+}
+
+func (m *Map) entry(key string) *MapEntry {
+	if m.Entries == nil {
+		m.Entries = make(map[string]*MapEntry)
+	}
+	entry, ok := m.Entries[key]
+	if !ok {
+		entry = &MapEntry{}
+		m.Entries[key] = entry
+	}
+	return entry
+}
+
+func (e *MapEntry) add(register Register) {
+	if containsTimestamp(e.Removed, register.Timestamp) {
+		return
+	}
+	for _, live := range e.Live {
+		if live.Timestamp == register.Timestamp {
+			return
+		}
+	}
+	e.Live = append(e.Live, register)
+}
+
+func (e *MapEntry) remove(timestamp Timestamp) {
+	if !containsTimestamp(e.Removed, timestamp) {
+		e.Removed = append(e.Removed, timestamp)
+	}
+	for i, live := range e.Live {
+		if live.Timestamp == timestamp {
+			e.Live = append(e.Live[:i], e.Live[i+1:]...)
+			return
+		}
+	}
+}
+
+func containsTimestamp(timestamps []Timestamp, timestamp Timestamp) bool {
+	for _, existing := range timestamps {
+		if existing == timestamp {
+			return true
+		}
+	}
+	return false
+}
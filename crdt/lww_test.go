@@ -0,0 +1,96 @@
+package crdt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestClock(t *testing.T) {
+	wall := time.UnixMilli(1000)
+	clock := NewClock(1)
+	clock.now = func() time.Time { return wall }
+
+	first := clock.Now()
+	second := clock.Now()
+	if !first.Before(second) || second.Wall != 1000 || second.Logical != 1 {
+		t.Errorf("timestamps in the same millisecond are not ordered: %+v, %+v\n", first, second)
+	}
+
+	// A remote clock running ahead pulls this one forward.
+	clock.Update(Timestamp{Wall: 5000, Logical: 3, Site: 2})
+	third := clock.Now()
+	if got, want := third, (Timestamp{Wall: 5000, Logical: 5, Site: 1}); got != want {
+		t.Errorf("timestamp mismatch; got = %+v, expected = %+v\n", got, want)
+	}
+
+	// Physical time going backwards does not move the clock backwards.
+	wall = time.UnixMilli(10)
+	if fourth := clock.Now(); !third.Before(fourth) {
+		t.Errorf("clock went backwards: %+v after %+v\n", fourth, third)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	var register Register
+	late := Timestamp{Wall: 2, Site: 1}
+	early := Timestamp{Wall: 1, Site: 2}
+	if !register.Set("late", late) {
+		t.Errorf("first write was not applied\n")
+	}
+	if register.Set("early", early) {
+		t.Errorf("earlier write overwrote a later one\n")
+	}
+	if register.Value != "late" {
+		t.Errorf("value mismatch; got = %v, expected = %v\n", register.Value, "late")
+	}
+}
+
+func TestMap_Converges(t *testing.T) {
+	alice, bob := NewMap(), NewMap()
+	aliceClock, bobClock := NewClock(1), NewClock(2)
+
+	initial := alice.Put("language", "go", aliceClock.Now())
+	bob.Apply(initial)
+	bobClock.Update(initial.Timestamp)
+
+	// Concurrent edits: alice removes the language and sets the title, bob changes the language twice.
+	aliceOperations := []MapOperation{
+		alice.Remove("language", aliceClock.Now()),
+		alice.Put("title", "two sum", aliceClock.Now()),
+	}
+	bobOperations := []MapOperation{
+		bob.Put("language", "python", bobClock.Now()),
+		bob.Put("language", "rust", bobClock.Now()),
+	}
+	for _, operation := range bobOperations {
+		alice.Apply(operation)
+	}
+	for _, operation := range aliceOperations {
+		bob.Apply(operation)
+		// Applying an operation twice is harmless.
+		bob.Apply(operation)
+	}
+
+	for name, replica := range map[string]*Map{"alice": alice, "bob": bob} {
+		got := map[string]string{}
+		for _, key := range replica.Keys() {
+			got[key], _ = replica.Get(key)
+		}
+		want := map[string]string{"language": "rust", "title": "two sum"}
+		if !cmp.Equal(got, want) {
+			t.Errorf("(%s) state mismatch; diff = %v\n", name, cmp.Diff(got, want))
+		}
+	}
+
+	joiner := NewMap()
+	joiner.Merge(alice)
+	joiner.Apply(alice.Remove("title", aliceClock.Now()))
+	if _, ok := joiner.Get("title"); ok {
+		t.Errorf("removed key is still present after merge\n")
+	}
+	if value, _ := joiner.Get("language"); value != "rust" {
+		t.Errorf("value mismatch; got = %v, expected = %v\n", value, "rust")
+	}
+}
@@ -0,0 +1,201 @@
+package crdt
+
+import "sync"
+
+// ChangeType describes the kind of modification reported to subscribers.
+type ChangeType string
+
+const (
+	ChangeInsert ChangeType = "insert"
+	ChangeDelete ChangeType = "delete"
+	ChangeReset  ChangeType = "reset"
+)
+
+// Change is emitted to subscribers after every modification of a SyncedDocument.
+// Position is the 1-based visible position the change applies to; it is zero for resets.
+type Change struct {
+	Type      ChangeType
+	Position  int
+	Character Character
+	Author    string
+}
+
+// SyncedDocument wraps a Document so it can be shared between goroutines,
+// and notifies subscribers of every change made through it.
+type SyncedDocument struct {
+	mutex    sync.RWMutex
+	document Document
+
+	// emitMutex keeps notifications in the same order as the changes they describe.
+	emitMutex   sync.Mutex
+	handlers    map[int]func(Change)
+	nextHandler int
+}
+
+func NewSyncedDocument(document Document) *SyncedDocument {
+	return &SyncedDocument{document: document, handlers: make(map[int]func(Change))}
+}
+
+// Subscribe registers handler to be called after every change, and returns a function that removes it.
+// Handlers run synchronously on the goroutine that made the change, so they must not modify the document.
+func (s *SyncedDocument) Subscribe(handler func(Change)) func() {
+	s.mutex.Lock()
+	id := s.nextHandler
+	s.nextHandler++
+	s.handlers[id] = handler
+	s.mutex.Unlock()
+	return func() {
+		s.mutex.Lock()
+		delete(s.handlers, id)
+		s.mutex.Unlock()
+	}
+}
+
+func (s *SyncedDocument) Insert(position int, value, author string) (Character, error) {
+	return s.InsertWithID(position, value, nextCharacterID(), author)
+}
+
+// InsertWithID inserts a character with the given ID, rather than one generated from SiteID and LocalClock,
+// for a replica that generates characters for several sites.
+func (s *SyncedDocument) InsertWithID(position int, value, id, author string) (Character, error) {
+	s.mutex.Lock()
+	character, err := s.document.insertWithID(position, value, id)
+	if err != nil {
+		s.mutex.Unlock()
+		return character, err
+	}
+	position = VisiblePosition(s.document, character.ID)
+	s.emit(Change{Type: ChangeInsert, Position: position, Character: character, Author: author})
+	return character, nil
+}
+
+// Delete hides the character at the given visible position. It reports false if there is no such character.
+func (s *SyncedDocument) Delete(position int, author string) (Character, bool) {
+	s.mutex.Lock()
+	character := IthVisible(s.document, position)
+	if character.ID == "-1" {
+		s.mutex.Unlock()
+		return character, false
+	}
+	s.document.IntegrateDelete(character)
+	s.emit(Change{Type: ChangeDelete, Position: position, Character: character, Author: author})
+	return character, true
+}
+
+// IntegrateInsert inserts a character generated by another replica between the neighbours it was generated with.
+// A character that is already present is ignored, and reported at position 0.
+func (s *SyncedDocument) IntegrateInsert(character Character, author string) (int, error) {
+	s.mutex.Lock()
+	if s.document.Contains(character.ID) {
+		s.mutex.Unlock()
+		return 0, nil
+	}
+	prevCharacter := s.document.Find(character.PrevID)
+	nextCharacter := s.document.Find(character.NextID)
+	if prevCharacter.ID == "-1" || nextCharacter.ID == "-1" {
+		s.mutex.Unlock()
+		return 0, ErrBoundsMissing
+	}
+	if _, err := s.document.IntegrateInsert(character, prevCharacter, nextCharacter); err != nil {
+		s.mutex.Unlock()
+		return 0, err
+	}
+	position := VisiblePosition(s.document, character.ID)
+	s.emit(Change{Type: ChangeInsert, Position: position, Character: character, Author: author})
+	return position, nil
+}
+
+// IntegrateDelete hides the character with the given ID. It reports false if it is missing or already hidden.
+func (s *SyncedDocument) IntegrateDelete(characterID, author string) (int, bool) {
+	s.mutex.Lock()
+	position := VisiblePosition(s.document, characterID)
+	if position == -1 {
+		s.mutex.Unlock()
+		return 0, false
+	}
+	character := s.document.Find(characterID)
+	s.document.IntegrateDelete(character)
+	s.emit(Change{Type: ChangeDelete, Position: position, Character: character, Author: author})
+	return position, true
+}
+
+// Replace swaps the whole document, for example after receiving a docSync.
+// The characters are copied, so the caller may keep using document, e.g. send it to other goroutines.
+func (s *SyncedDocument) Replace(document Document, author string) {
+	characters := make([]Character, len(document.Characters))
+	copy(characters, document.Characters)
+	s.mutex.Lock()
+	s.document = Document{Characters: characters}
+	s.emit(Change{Type: ChangeReset, Author: author})
+}
+
+func (s *SyncedDocument) Validate() []Violation {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.document.Validate()
+}
+
+// Repair repairs the document in place and returns the violations it fixed.
+// Subscribers are sent a reset if anything changed.
+func (s *SyncedDocument) Repair(author string) []Violation {
+	s.mutex.Lock()
+	violations := s.document.Repair()
+	if len(violations) == 0 {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.emit(Change{Type: ChangeReset, Author: author})
+	return violations
+}
+
+// Contains reports whether the document has a character with the given ID, visible or not.
+func (s *SyncedDocument) Contains(characterID string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.document.Contains(characterID)
+}
+
+func (s *SyncedDocument) Content() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return Content(s.document)
+}
+
+// Anchor returns the anchor of a position, see AnchorAt.
+func (s *SyncedDocument) Anchor(position int) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return AnchorAt(s.document, position)
+}
+
+// AnchorPosition returns the position of an anchor, see AnchorPosition.
+func (s *SyncedDocument) AnchorPosition(anchor string) int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return AnchorPosition(s.document, anchor)
+}
+
+// Snapshot returns a copy of the document that is safe to use without holding any lock.
+func (s *SyncedDocument) Snapshot() Document {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	characters := make([]Character, len(s.document.Characters))
+	copy(characters, s.document.Characters)
+	return Document{Characters: characters}
+}
+
+// emit must be called with s.mutex held; it releases it before running the handlers.
+func (s *SyncedDocument) emit(change Change) {
+	handlers := make([]func(Change), 0, len(s.handlers))
+	for id := 0; id < s.nextHandler; id++ {
+		if handler, ok := s.handlers[id]; ok {
+			handlers = append(handlers, handler)
+		}
+	}
+	s.emitMutex.Lock()
+	s.mutex.Unlock()
+	defer s.emitMutex.Unlock()
+	for _, handler := range handlers {
+		handler(change)
+	}
+}
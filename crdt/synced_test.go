@@ -0,0 +1,113 @@
+package crdt
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSyncedDocument_Subscribe(t *testing.T) {
+	document := NewSyncedDocument(New())
+	var changes []Change
+	unsubscribe := document.Subscribe(func(change Change) {
+		change.Character = Character{}
+		changes = append(changes, change)
+	})
+	if _, err := document.Insert(1, "a", "alice"); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if _, err := document.Insert(1, "b", "bob"); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if _, ok := document.Delete(2, "alice"); !ok {
+		t.Fatalf("delete of existing character reported no change")
+	}
+	if _, ok := document.Delete(5, "alice"); ok {
+		t.Fatalf("delete out of range reported a change")
+	}
+	document.Replace(New(), "bob")
+	unsubscribe()
+	if _, err := document.Insert(1, "c", "alice"); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	expected := []Change{
+		{Type: ChangeInsert, Position: 1, Author: "alice"},
+		{Type: ChangeInsert, Position: 1, Author: "bob"},
+		{Type: ChangeDelete, Position: 2, Author: "alice"},
+		{Type: ChangeReset, Author: "bob"},
+	}
+	if !cmp.Equal(changes, expected) {
+		t.Errorf("changes mismatch; diff = %v\n", cmp.Diff(changes, expected))
+	}
+	if got, want := document.Content(), "c"; got != want {
+		t.Errorf("content mismatch; got = %v, expected = %v\n", got, want)
+	}
+}
+
+func TestSyncedDocument_Concurrent(t *testing.T) {
+	document := NewSyncedDocument(New())
+	var mutex sync.Mutex
+	count := 0
+	document.Subscribe(func(change Change) {
+		mutex.Lock()
+		count++
+		mutex.Unlock()
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if _, err := document.Insert(1, "x", "writer"); err != nil {
+					t.Errorf("error: %v\n", err)
+				}
+				_ = document.Snapshot()
+			}
+		}()
+	}
+	wg.Wait()
+	if got, want := len([]rune(document.Content())), 400; got != want {
+		t.Errorf("length mismatch; got = %v, expected = %v\n", got, want)
+	}
+	if count != 400 {
+		t.Errorf("notification count mismatch; got = %v, expected = %v\n", count, 400)
+	}
+}
+
+func TestSyncedDocument_InsertWithID(t *testing.T) {
+	document := NewSyncedDocument(New())
+	clock := ReadLocalClock()
+	if _, err := document.Insert(1, "a", "alice"); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	character, err := document.InsertWithID(2, "b", "7.1", "server")
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if character.ID != "7.1" || character.PrevID == StartCharacter.ID {
+		t.Errorf("character mismatch; got = %+v, expected = %v after the first character\n", character, "7.1")
+	}
+	if got, want := document.Content(), "ab"; got != want {
+		t.Errorf("content mismatch; got = %v, expected = %v\n", got, want)
+	}
+	// The local clock only counts the local site's characters.
+	if got, want := ReadLocalClock(), clock+1; got != want {
+		t.Errorf("clock mismatch; got = %v, expected = %v\n", got, want)
+	}
+}
+
+func TestSyncedDocument_ReplaceCopies(t *testing.T) {
+	source := NewSyncedDocument(New())
+	if _, err := source.InsertWithID(1, "a", "1.1", "alice"); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	replacement := source.Snapshot()
+	document := NewSyncedDocument(New())
+	document.Replace(replacement, "alice")
+	document.IntegrateDelete("1.1", "bob")
+	if got, want := Content(replacement), "a"; got != want {
+		t.Errorf("replacement content mismatch; got = %v, expected = %v\n", got, want)
+	}
+}
@@ -0,0 +1,99 @@
+package crdt
+
+import "fmt"
+
+// Violation describes a structural problem in a Document, found by Validate.
+type Violation struct {
+	// Index is the position of the offending character in Characters, or -1 if the problem is a missing character.
+	Index       int
+	CharacterID string
+	Problem     string
+}
+
+func (v Violation) Error() string {
+	return fmt.Sprintf("character %q at index %d: %s", v.CharacterID, v.Index, v.Problem)
+}
+
+// Validate checks the invariants the WOOT algorithm relies on and returns every violation found:
+// the document starts with StartCharacter and ends with EndCharacter, IDs are present and unique,
+// the sentinels are invisible, and PrevID/NextID link each character to its neighbours.
+func (document *Document) Validate() []Violation {
+	var violations []Violation
+	report := func(index int, id, format string, args ...any) {
+		violations = append(violations, Violation{Index: index, CharacterID: id, Problem: fmt.Sprintf(format, args...)})
+	}
+	characters := document.Characters
+	if len(characters) == 0 || characters[0].ID != StartCharacter.ID {
+		report(-1, StartCharacter.ID, "start sentinel is not the first character")
+	}
+	if len(characters) == 0 || characters[len(characters)-1].ID != EndCharacter.ID {
+		report(-1, EndCharacter.ID, "end sentinel is not the last character")
+	}
+	seen := make(map[string]int, len(characters))
+	for i, character := range characters {
+		if character.ID == "" {
+			report(i, character.ID, "empty ID")
+		}
+		if first, ok := seen[character.ID]; ok {
+			report(i, character.ID, "duplicate of the character at index %d", first)
+		} else {
+			seen[character.ID] = i
+		}
+		isSentinel := character.ID == StartCharacter.ID || character.ID == EndCharacter.ID
+		if isSentinel && character.Visible {
+			report(i, character.ID, "sentinel is visible")
+		}
+		if (i == 0 || i == len(characters)-1) && !isSentinel {
+			continue
+		}
+		expectedPrev, expectedNext := "", ""
+		if i > 0 {
+			expectedPrev = characters[i-1].ID
+		}
+		if i < len(characters)-1 {
+			expectedNext = characters[i+1].ID
+		}
+		if character.PrevID != expectedPrev {
+			report(i, character.ID, "PrevID is %q, expected %q", character.PrevID, expectedPrev)
+		}
+		if character.NextID != expectedNext {
+			report(i, character.ID, "NextID is %q, expected %q", character.NextID, expectedNext)
+		}
+	}
+	return violations
+}
+
+// Repair fixes the violations reported by Validate, which it returns.
+// Characters with empty or duplicate IDs are dropped (a duplicate stays deleted if any copy was),
+// the sentinels are restored at both ends, and all links are rebuilt from the order of Characters.
+func (document *Document) Repair() []Violation {
+	violations := document.Validate()
+	if len(violations) == 0 {
+		return nil
+	}
+	repaired := []Character{StartCharacter}
+	seen := make(map[string]int, len(document.Characters))
+	for _, character := range document.Characters {
+		if character.ID == "" || character.ID == StartCharacter.ID || character.ID == EndCharacter.ID {
+			continue
+		}
+		if index, ok := seen[character.ID]; ok {
+			repaired[index].Visible = repaired[index].Visible && character.Visible
+			continue
+		}
+		seen[character.ID] = len(repaired)
+		repaired = append(repaired, character)
+	}
+	repaired = append(repaired, EndCharacter)
+	for i := range repaired {
+		repaired[i].PrevID, repaired[i].NextID = "", ""
+		if i > 0 {
+			repaired[i].PrevID = repaired[i-1].ID
+		}
+		if i < len(repaired)-1 {
+			repaired[i].NextID = repaired[i+1].ID
+		}
+	}
+	document.Characters = repaired
+	return violations
+}
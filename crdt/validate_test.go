@@ -0,0 +1,83 @@
+package crdt
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func assertValid(t *testing.T, document *Document) {
+	t.Helper()
+	for _, violation := range document.Validate() {
+		t.Errorf("invalid document: %v\n", violation)
+	}
+}
+
+func TestValidate_AfterEdits(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	document := New()
+	for i := 0; i < 500; i++ {
+		length := len([]rune(Content(document)))
+		if length > 0 && random.Intn(3) == 0 {
+			document.Delete(random.Intn(length) + 1)
+		} else if _, err := document.Insert(random.Intn(length+1)+1, "x"); err != nil {
+			t.Fatalf("error: %v\n", err)
+		}
+	}
+	assertValid(t, &document)
+}
+
+func TestValidate_Violations(t *testing.T) {
+	document := &Document{
+		Characters: []Character{
+			{ID: "1", Visible: true, Value: "a", PrevID: "start", NextID: "2"},
+			{ID: "start", Visible: true, Value: "", PrevID: "", NextID: "1"},
+			{ID: "2", Visible: true, Value: "b", PrevID: "1", NextID: "2"},
+			{ID: "2", Visible: false, Value: "b", PrevID: "2", NextID: "end"},
+			{ID: "", Visible: true, Value: "c", PrevID: "2", NextID: "end"},
+			{ID: "end", Visible: false, Value: "", PrevID: "2", NextID: ""},
+		},
+	}
+	violations := document.Validate()
+	problems := make(map[string]bool)
+	for _, violation := range violations {
+		problems[violation.Problem] = true
+	}
+	for _, expected := range []string{
+		"start sentinel is not the first character",
+		"sentinel is visible",
+		"duplicate of the character at index 2",
+		"empty ID",
+		`PrevID is "2", expected ""`,
+	} {
+		if !problems[expected] {
+			t.Errorf("missing violation %q in %v\n", expected, violations)
+		}
+	}
+
+	fixed := document.Repair()
+	if !cmp.Equal(fixed, violations) {
+		t.Errorf("repair reported different violations; diff = %v\n", cmp.Diff(fixed, violations))
+	}
+	assertValid(t, document)
+	if got, want := Content(*document), "a"; got != want {
+		t.Errorf("content mismatch; got = %v, expected = %v\n", got, want)
+	}
+	if document.Repair() != nil {
+		t.Errorf("repair of a valid document reported violations\n")
+	}
+}
+
+func TestLeftRight(t *testing.T) {
+	document := New()
+	if _, err := document.Insert(1, "a"); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	id := IthVisible(document, 1).ID
+	got := []string{document.Left(id), document.Right(id), document.Left("start"), document.Right("end"), document.Left("missing"), document.Right("missing")}
+	want := []string{"start", "end", "start", "end", "", ""}
+	if !cmp.Equal(got, want) {
+		t.Errorf("neighbour mismatch; diff = %v\n", cmp.Diff(got, want))
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 type Document struct {
@@ -24,6 +26,8 @@ var (
 
 	LocalClock = 0
 
+	clockMutex sync.Mutex
+
 	StartCharacter = Character{ID: "start", Visible: false, Value: "", PrevID: "", NextID: "end"}
 
 	EndCharacter = Character{ID: "end", Visible: false, Value: "", PrevID: "start", NextID: ""}
@@ -98,6 +102,22 @@ func IthVisible(document Document, visiblePosition int) Character {
 	return Character{ID: "-1"}
 }
 
+func VisiblePosition(document Document, characterID string) int {
+	visibleCount := 0
+	for _, character := range document.Characters {
+		if character.ID == characterID {
+			if !character.Visible {
+				return -1
+			}
+			return visibleCount + 1
+		}
+		if character.Visible {
+			visibleCount++
+		}
+	}
+	return -1
+}
+
 func (document *Document) Length() int {
 	return len(document.Characters)
 }
@@ -119,17 +139,23 @@ func (document *Document) Position(characterID string) int {
 }
 
 func (document *Document) Left(characterID string) string {
-	index := document.Position(characterID)
-	if index <= 0 {
+	index := document.Position(characterID) - 1
+	if index < 0 {
+		return ""
+	}
+	if index == 0 {
 		return document.Characters[index].ID
 	}
 	return document.Characters[index-1].ID
 }
 
 func (document *Document) Right(characterID string) string {
-	index := document.Position(characterID)
+	index := document.Position(characterID) - 1
+	if index < 0 {
+		return ""
+	}
 	if index >= len(document.Characters)-1 {
-		return document.Characters[index-1].ID
+		return document.Characters[index].ID
 	}
 	return document.Characters[index+1].ID
 }
@@ -174,6 +200,8 @@ func (document *Document) LocalInsert(character Character, position int) (*Docum
 	)
 	document.Characters[position-1].NextID = character.ID
 	document.Characters[position+1].PrevID = character.ID
+	document.Characters[position].PrevID = document.Characters[position-1].ID
+	document.Characters[position].NextID = document.Characters[position+1].ID
 	return document, nil
 }
 
@@ -197,8 +225,68 @@ func (document *Document) IntegrateInsert(character, prevCharacter, nextCharacte
 	return document.IntegrateInsert(character, subsequence[index-1], subsequence[index])
 }
 
-func (document *Document) GenerateInsert(position int, value string) (*Document, error) {
+func nextCharacterID() string {
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
 	LocalClock++
+	return fmt.Sprintf("%d.%d", SiteID, LocalClock)
+}
+
+// ReadLocalClock returns the current value of LocalClock.
+func ReadLocalClock() int {
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
+	return LocalClock
+}
+
+// AdvanceLocalClock moves LocalClock forward to clock, so IDs generated before a restart are not reused.
+func AdvanceLocalClock(clock int) {
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
+	LocalClock = max(LocalClock, clock)
+}
+
+// MaxSiteID returns the highest site ID that generated any of the document's characters.
+func MaxSiteID(document Document) int {
+	maxSiteID := 0
+	for _, character := range document.Characters {
+		site, _, found := strings.Cut(character.ID, ".")
+		if !found {
+			continue
+		}
+		if siteID, err := strconv.Atoi(site); err == nil {
+			maxSiteID = max(maxSiteID, siteID)
+		}
+	}
+	return maxSiteID
+}
+
+// MaxClock returns the highest clock value the given site used for any of the document's characters.
+func MaxClock(document Document, siteID int) int {
+	maxClock := 0
+	for _, character := range document.Characters {
+		site, clock, found := strings.Cut(character.ID, ".")
+		if !found || site != strconv.Itoa(siteID) {
+			continue
+		}
+		if value, err := strconv.Atoi(clock); err == nil {
+			maxClock = max(maxClock, value)
+		}
+	}
+	return maxClock
+}
+
+func (document *Document) GenerateInsert(position int, value string) (*Document, error) {
+	_, err := document.generateInsert(position, value)
+	return document, err
+}
+
+func (document *Document) generateInsert(position int, value string) (Character, error) {
+	return document.insertWithID(position, value, nextCharacterID())
+}
+
+// insertWithID inserts a character with the given ID at a visible position.
+func (document *Document) insertWithID(position int, value, id string) (Character, error) {
 	prevCharacter := IthVisible(*document, position-1)
 	nextCharacter := IthVisible(*document, position)
 	if prevCharacter.ID == "-1" {
@@ -208,13 +296,14 @@ func (document *Document) GenerateInsert(position int, value string) (*Document,
 		nextCharacter = document.Find("end")
 	}
 	character := Character{
-		ID:      fmt.Sprint(SiteID) + fmt.Sprint(LocalClock),
+		ID:      id,
 		Visible: true,
 		Value:   value,
 		PrevID:  prevCharacter.ID,
 		NextID:  nextCharacter.ID,
 	}
-	return document.IntegrateInsert(character, prevCharacter, nextCharacter)
+	_, err := document.IntegrateInsert(character, prevCharacter, nextCharacter)
+	return character, err
 }
 
 func (document *Document) IntegrateDelete(character Character) *Document {
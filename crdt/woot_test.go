@@ -31,6 +31,7 @@ func TestInsert(t *testing.T) {
 			{ID: "end", Visible: false, Value: "", PrevID: "1", NextID: ""},
 		},
 	}
+	assertValid(t, &document)
 	got := content
 	want := Content(*expectedDocument)
 	if got != want {
@@ -125,9 +126,27 @@ func TestLoad(t *testing.T) {
 	if err != nil {
 		t.Fatalf("error: %v\n", err)
 	}
+	assertValid(t, &loadedDocument)
 	got := Content(loadedDocument)
 	want := Content(*document)
 	if !cmp.Equal(got, want) {
 		t.Errorf("content mismatch; diff = %v\n", cmp.Diff(got, want))
 	}
 }
+
+func TestMaxSiteIDAndClock(t *testing.T) {
+	document := Document{
+		Characters: []Character{
+			{ID: "start", Visible: false, Value: "", PrevID: "", NextID: "2.7"},
+			{ID: "2.7", Visible: true, Value: "a", PrevID: "start", NextID: "12.3"},
+			{ID: "12.3", Visible: false, Value: "b", PrevID: "2.7", NextID: "2.10"},
+			{ID: "2.10", Visible: true, Value: "c", PrevID: "12.3", NextID: "end"},
+			{ID: "end", Visible: false, Value: "", PrevID: "2.10", NextID: ""},
+		},
+	}
+	got := []int{MaxSiteID(document), MaxClock(document, 2), MaxClock(document, 12), MaxClock(document, 1)}
+	expected := []int{12, 10, 3, 0}
+	if !cmp.Equal(got, expected) {
+		t.Errorf("got != expected, diff: %v\n", cmp.Diff(got, expected))
+	}
+}
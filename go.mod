@@ -6,7 +6,6 @@ toolchain go1.23.10
 
 require (
 	github.com/Pallinder/go-randomdata v1.2.0
-	github.com/fatih/color v1.18.0
 	github.com/google/go-cmp v0.5.9
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/websocket v1.5.3
@@ -16,8 +15,6 @@ require (
 )
 
 require (
-	github.com/mattn/go-colorable v0.1.14 // indirect
-	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
 	golang.org/x/sys v0.33.0 // indirect
 	golang.org/x/text v0.5.0 // indirect
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/omesh-barhate/coderpad/commons"
+)
+
+// The admin API lets operators manage rooms without a client. It is served on its own listener (-admin-addr),
+// so it can be kept off the public network, and every request needs the admin token as a bearer token:
+//   - GET /rooms lists the rooms;
+//   - GET /rooms/{room}/participants lists a room's clients;
+//   - GET /rooms/{room}/content returns a room's document;
+//   - DELETE /rooms/{room}/participants/{client} disconnects a client;
+//   - DELETE /rooms/{room} disconnects every client of a room, and unloads it.
+
+type adminRoom struct {
+	ID       string `json:"id"`
+	Clients  int    `json:"clients"`
+	Sequence uint64 `json:"seq"`
+}
+
+type adminParticipant struct {
+	ClientID uuid.UUID    `json:"clientId"`
+	Username string       `json:"username"`
+	SiteID   string       `json:"siteId"`
+	Role     commons.Role `json:"role"`
+}
+
+type adminContent struct {
+	Room     string `json:"room"`
+	Sequence uint64 `json:"seq"`
+	Content  string `json:"content"`
+}
+
+type adminError struct {
+	Error string `json:"error"`
+}
+
+func newAdminMux(hub *Hub, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rooms", func(response http.ResponseWriter, request *http.Request) {
+		rooms := []adminRoom{}
+		hub.call(func() {
+			for _, room := range hub.rooms {
+				rooms = append(rooms, adminRoom{ID: room.ID, Clients: len(room.clients), Sequence: room.sequence})
+			}
+		})
+		sort.Slice(rooms, func(i, j int) bool { return rooms[i].ID < rooms[j].ID })
+		writeJSON(response, http.StatusOK, rooms)
+	})
+	mux.HandleFunc("GET /rooms/{room}/participants", func(response http.ResponseWriter, request *http.Request) {
+		participants := []adminParticipant{}
+		found := hub.withRoom(request.PathValue("room"), func(room *Room) {
+			for _, client := range room.clients {
+				participants = append(participants, adminParticipant{ClientID: client.ID, Username: client.Username, SiteID: client.SiteID, Role: client.Role})
+			}
+		})
+		if !found {
+			writeJSON(response, http.StatusNotFound, adminError{Error: "no such room"})
+			return
+		}
+		sort.Slice(participants, func(i, j int) bool {
+			first, _ := strconv.Atoi(participants[i].SiteID)
+			second, _ := strconv.Atoi(participants[j].SiteID)
+			return first < second
+		})
+		writeJSON(response, http.StatusOK, participants)
+	})
+	mux.HandleFunc("GET /rooms/{room}/content", func(response http.ResponseWriter, request *http.Request) {
+		var content adminContent
+		found := hub.withRoom(request.PathValue("room"), func(room *Room) {
+			content = adminContent{Room: room.ID, Sequence: room.sequence, Content: room.document.Content()}
+		})
+		if !found {
+			writeJSON(response, http.StatusNotFound, adminError{Error: "no such room"})
+			return
+		}
+		writeJSON(response, http.StatusOK, content)
+	})
+	mux.HandleFunc("DELETE /rooms/{room}/participants/{client}", func(response http.ResponseWriter, request *http.Request) {
+		clientID, err := uuid.Parse(request.PathValue("client"))
+		if err != nil {
+			writeJSON(response, http.StatusNotFound, adminError{Error: "no such participant"})
+			return
+		}
+		kicked := false
+		hub.withRoom(request.PathValue("room"), func(room *Room) {
+			if client, ok := room.clients[clientID]; ok {
+				requestLogger(request).Info("kicking client", slog.String("room", room.ID), slog.String("client", client.ID.String()))
+				hub.kick(client, disconnectKicked, commons.CloseKicked, kickedReason)
+				kicked = true
+			}
+		})
+		if !kicked {
+			writeJSON(response, http.StatusNotFound, adminError{Error: "no such participant"})
+			return
+		}
+		response.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /rooms/{room}", func(response http.ResponseWriter, request *http.Request) {
+		var err error
+		found := hub.withRoom(request.PathValue("room"), func(room *Room) {
+			requestLogger(request).Info("closing room", slog.String("room", room.ID))
+			err = hub.closeRoom(room)
+		})
+		if !found {
+			writeJSON(response, http.StatusNotFound, adminError{Error: "no such room"})
+			return
+		}
+		if err != nil {
+			writeJSON(response, http.StatusInternalServerError, adminError{Error: "room closed, but not persisted: " + err.Error()})
+			return
+		}
+		response.WriteHeader(http.StatusNoContent)
+	})
+	return requireAdminToken(token, mux)
+}
+
+// withRoom runs function on the hub goroutine with the room with the given ID, if it is loaded, and reports whether it is.
+func (hub *Hub) withRoom(roomID string, function func(*Room)) bool {
+	found := false
+	hub.call(func() {
+		if room, ok := hub.rooms[roomID]; ok {
+			function(room)
+			found = true
+		}
+	})
+	return found
+}
+
+func requireAdminToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		presented, ok := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			writeJSON(response, http.StatusUnauthorized, adminError{Error: "invalid admin token"})
+			return
+		}
+		next.ServeHTTP(response, request)
+	})
+}
+
+func writeJSON(response http.ResponseWriter, status int, value any) {
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+	if err := json.NewEncoder(response).Encode(value); err != nil {
+		slog.Warn("failed to write admin response", slog.Any("error", err))
+	}
+}
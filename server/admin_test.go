@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/omesh-barhate/coderpad/commons"
+)
+
+// adminRequest makes a request to the admin API, and decodes its JSON response into result, if given.
+func adminRequest(t *testing.T, admin *httptest.Server, token, method, path string, result any) int {
+	t.Helper()
+	request, err := http.NewRequest(method, admin.URL+path, nil)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	defer response.Body.Close()
+	if result != nil && response.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+			t.Fatalf("decode error: %v\n", err)
+		}
+	}
+	return response.StatusCode
+}
+
+func expectClose(t *testing.T, connection *websocket.Conn, code int) {
+	t.Helper()
+	for {
+		if _, _, err := connection.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, code) {
+				t.Errorf("close mismatch; got = %v, expected = %v\n", err, code)
+			}
+			return
+		}
+	}
+}
+
+func TestAdminAPI(t *testing.T) {
+	server, hub := newTestServer(t)
+	admin := httptest.NewServer(newAdminMux(hub, "admin-token"))
+	t.Cleanup(admin.Close)
+	path := uniqueRoom("admin")
+	roomID := strings.TrimPrefix(path, "/pad/")
+
+	if status := adminRequest(t, admin, "wrong", http.MethodGet, "/rooms", nil); status != http.StatusUnauthorized {
+		t.Errorf("status mismatch without the admin token; got = %v, expected = %v\n", status, http.StatusUnauthorized)
+	}
+
+	alice, aliceSite := dial(t, server, path)
+	bob, bobSite := dial(t, server, path)
+	for _, message := range []commons.Message{{MessageType: commons.JoinMessage, Username: "alice"}, insertMessage(1, "x")} {
+		if err := alice.WriteJSON(&message); err != nil {
+			t.Fatalf("write error: %v\n", err)
+		}
+	}
+	readUntil(t, alice, commons.AckMessage)
+
+	var rooms []adminRoom
+	adminRequest(t, admin, "admin-token", http.MethodGet, "/rooms", &rooms)
+	if len(rooms) != 1 || rooms[0].ID != roomID || rooms[0].Clients != 2 {
+		t.Errorf("rooms mismatch; got = %+v\n", rooms)
+	}
+	var participants []adminParticipant
+	adminRequest(t, admin, "admin-token", http.MethodGet, "/rooms/"+roomID+"/participants", &participants)
+	if len(participants) != 2 || participants[0].Username != "alice" || participants[0].SiteID != aliceSite.Text || participants[1].SiteID != bobSite.Text {
+		t.Errorf("participants mismatch; got = %+v\n", participants)
+	}
+	var content adminContent
+	adminRequest(t, admin, "admin-token", http.MethodGet, "/rooms/"+roomID+"/content", &content)
+	if content.Content != "x" {
+		t.Errorf("content mismatch; got = %v, expected = %v\n", content.Content, "x")
+	}
+
+	if status := adminRequest(t, admin, "admin-token", http.MethodDelete, "/rooms/"+roomID+"/participants/"+bobSite.ClientID.String(), nil); status != http.StatusNoContent {
+		t.Errorf("kick status mismatch; got = %v, expected = %v\n", status, http.StatusNoContent)
+	}
+	expectClose(t, bob, commons.CloseKicked)
+	if leave := readUntil(t, alice, commons.LeaveMessage); leave.ClientID != bobSite.ClientID {
+		t.Errorf("leave mismatch; got = %v, expected = %v\n", leave.ClientID, bobSite.ClientID)
+	}
+
+	if status := adminRequest(t, admin, "admin-token", http.MethodDelete, "/rooms/"+roomID, nil); status != http.StatusNoContent {
+		t.Errorf("close status mismatch; got = %v, expected = %v\n", status, http.StatusNoContent)
+	}
+	expectClose(t, alice, commons.CloseRoomClosed)
+	if status := adminRequest(t, admin, "admin-token", http.MethodGet, "/rooms/"+roomID+"/content", nil); status != http.StatusNotFound {
+		t.Errorf("status mismatch for a closed room; got = %v, expected = %v\n", status, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/omesh-barhate/coderpad/commons"
+)
+
+// writeTimeout is how long writing a message to a client may take.
+const writeTimeout = 10 * time.Second
+
+// Client is a websocket connection to a room.
+// Only its writePump goroutine writes to the connection, and only its readPump goroutine reads from it;
+// the hub hands it outbound messages through the send queue.
+type Client struct {
+	ID   uuid.UUID
+	room *Room
+	conn *websocket.Conn
+	// logger carries the client's room and ID, and the ID of the request that connected it.
+	logger *slog.Logger
+	send   chan outboundMessage
+	Role   commons.Role
+
+	pingInterval, pongTimeout time.Duration
+	// limiter is only used by readPump, and is nil if messages aren't rate limited.
+	limiter        *rateLimiter
+	maxViolations  int
+	maxMessageSize int64
+
+	// resumeFrom is the sequence number of the last change a reconnecting client saw, if resuming is set.
+	resumeFrom uint64
+	resuming   bool
+	// session is the hash of the client's session secret, or empty if it didn't send one.
+	session string
+
+	// Username, SiteID and presence are owned by the hub goroutine.
+	Username string
+	SiteID   string
+	// presence is the client's latest presence message, sent to clients joining after it.
+	presence *commons.Message
+	// lastChange is the ID of the latest change the client was answered, sent along a resync's state.
+	lastChange uint64
+	// closeCode and closeReason are sent in the close message once the hub closes the send queue.
+	closeCode   int
+	closeReason string
+
+	// done is closed once writePump has sent every queued message and closed the connection.
+	done chan struct{}
+}
+
+// outboundMessage is a message queued for a client, and when it was queued.
+type outboundMessage struct {
+	message commons.Message
+	queued  time.Time
+}
+
+// newClient returns a client of the room, logging with logger and the room and client IDs.
+func newClient(room *Room, conn *websocket.Conn, role commons.Role, logger *slog.Logger) *Client {
+	id := uuid.New()
+	client := &Client{
+		ID:     id,
+		room:   room,
+		logger: logger.With(slog.String("room", room.ID), slog.String("client", id.String())),
+		conn:   conn,
+		send:   make(chan outboundMessage, config.SendQueue),
+		Role:   role,
+
+		pingInterval: config.PingInterval,
+		pongTimeout:  config.PongTimeout,
+
+		maxViolations:  config.MaxViolations,
+		maxMessageSize: config.MaxMessageSize,
+
+		closeCode: websocket.CloseNormalClosure,
+		done:      make(chan struct{}),
+	}
+	if config.RateLimit > 0 {
+		client.limiter = newRateLimiter(config.RateLimit, config.RateBurst, time.Now())
+	}
+	return client
+}
+
+// readPump forwards every message from the connection to the hub, until the connection fails,
+// nothing (not even a pong) is received for pongTimeout, or the client sends a message larger than maxMessageSize
+// or stays over the rate limit for more than maxViolations seconds.
+func (client *Client) readPump(hub *Hub) {
+	reason := disconnectClosed
+	defer func() {
+		hub.unregister <- departure{client: client, reason: reason}
+	}()
+	_ = client.conn.SetReadDeadline(time.Now().Add(client.pongTimeout))
+	client.conn.SetPongHandler(func(string) error {
+		return client.conn.SetReadDeadline(time.Now().Add(client.pongTimeout))
+	})
+	for {
+		message, err := client.readMessage(hub)
+		if err != nil && !errors.Is(err, errMalformedMessage) {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				client.logger.Warn("no response from client", slog.Duration("timeout", client.pongTimeout))
+				reason = disconnectTimeout
+			} else if errors.Is(err, errMessageTooLarge) {
+				client.logger.Warn("message too large", slog.Int64("limit", client.maxMessageSize))
+				hub.call(func() {
+					hub.send(client, errorMessage(commons.ErrorMessageTooLarge, fmt.Sprintf("messages are limited to %d bytes", client.maxMessageSize)))
+					hub.kick(client, disconnectTooLarge, websocket.CloseMessageTooBig, tooLargeReason)
+				})
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				client.logger.Warn("read error", slog.Any("error", err))
+			}
+			return
+		}
+		if !client.throttle(hub) {
+			return
+		}
+		_ = client.conn.SetReadDeadline(time.Now().Add(client.pongTimeout))
+		if err != nil {
+			hub.notify(client, errorMessage(commons.ErrorMalformedMessage, err.Error()))
+			continue
+		}
+		if invalid := validateMessage(message); invalid != nil {
+			client.logger.Warn("rejected invalid message", messageAttr(message), slog.String("code", string(invalid.Code)), slog.String("error", invalid.Text))
+			hub.call(func() {
+				if client.room.clients[client.ID] == client {
+					hub.answer(client, message.ChangeID, commons.Message{MessageType: commons.ErrorMessage, Error: invalid})
+				}
+			})
+			continue
+		}
+		message.ClientID = client.ID
+		hub.inbound <- inboundMessage{client: client, message: message}
+	}
+}
+
+// throttle delays a message to keep the client within its rate limit, warning it when it first goes over.
+// If the client stayed over the limit for too long, it is disconnected, and throttle returns false.
+func (client *Client) throttle(hub *Hub) bool {
+	if client.limiter == nil {
+		return true
+	}
+	delay, first := client.limiter.take(time.Now())
+	if delay == 0 {
+		return true
+	}
+	if client.limiter.violations > client.maxViolations {
+		client.logger.Warn("over the rate limit for too long", slog.Int("violations", client.limiter.violations))
+		hub.call(func() {
+			hub.kick(client, disconnectRateLimited, websocket.ClosePolicyViolation, rateLimitedReason)
+		})
+		return false
+	}
+	if first {
+		hub.notify(client, errorMessage(commons.ErrorRateLimited, fmt.Sprintf("more than %g messages per second, slowing down", client.limiter.rate)))
+	}
+	time.Sleep(delay)
+	return true
+}
+
+// readMessage reads and decodes the next message from the connection.
+func (client *Client) readMessage(hub *Hub) (commons.Message, error) {
+	var message commons.Message
+	_, reader, err := client.conn.NextReader()
+	if err != nil {
+		return message, err
+	}
+	// The limit is checked here rather than with the connection's read limit, which closes the connection
+	// before the client can be told why.
+	data, err := io.ReadAll(io.LimitReader(reader, client.maxMessageSize+1))
+	if err != nil {
+		return message, err
+	}
+	if int64(len(data)) > client.maxMessageSize {
+		return message, errMessageTooLarge
+	}
+	hub.bytesIn.Add(uint64(len(data)))
+	if err := json.Unmarshal(data, &message); err != nil {
+		return message, fmt.Errorf("%w: %v", errMalformedMessage, err)
+	}
+	return message, nil
+}
+
+// writePump writes queued messages to the connection until the hub closes the queue, and pings the client every pingInterval.
+// Messages queued before the queue was closed are still sent, followed by a close message.
+// If a write fails, the connection is closed, which makes readPump unregister the client.
+func (client *Client) writePump(hub *Hub) {
+	pingTicker := time.NewTicker(client.pingInterval)
+	defer func() {
+		pingTicker.Stop()
+		client.conn.Close()
+		close(client.done)
+	}()
+	for {
+		select {
+		case outbound, ok := <-client.send:
+			_ = client.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if !ok {
+				_ = client.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(client.closeCode, client.closeReason))
+				return
+			}
+			data, err := json.Marshal(&outbound.message)
+			if err == nil {
+				err = client.conn.WriteMessage(websocket.TextMessage, data)
+			}
+			if err != nil {
+				client.logger.Warn("send error", slog.Any("error", err))
+				return
+			}
+			hub.bytesOut.Add(uint64(len(data)))
+			hub.broadcastLatency.observe(time.Since(outbound.queued))
+		case <-pingTicker.C:
+			if err := client.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				client.logger.Warn("ping error", slog.Any("error", err))
+				return
+			}
+		}
+	}
+}
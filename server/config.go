@@ -0,0 +1,238 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Every setting is a flag, a key of the JSON configuration file and an environment variable with the same name:
+// -data-dir, "data-dir" and $CODERPAD_DATA_DIR. Flags override environment variables, which override the file,
+// which overrides the defaults. The three are parsed the same way, by the setting's flag.
+
+// environmentPrefix starts the name of every setting's environment variable.
+const environmentPrefix = "CODERPAD_"
+
+// secretSettings are left out of -print-config's output.
+var secretSettings = map[string]bool{"token-secret": true, "admin-token": true}
+
+// Config is the server's configuration.
+type Config struct {
+	Addr    string
+	DataDir string
+	// SnapshotEvery is the number of logged operations after which a room is snapshotted.
+	SnapshotEvery int
+	SendQueue     int
+	SlowClient    string
+	// TokenSecret signs access tokens. If it is empty, anyone can join any room.
+	TokenSecret string
+
+	PingInterval  time.Duration
+	PongTimeout   time.Duration
+	ResumeHistory int
+
+	// RateLimit is how many messages per second a client may send, after a burst of RateBurst; zero disables the limit.
+	RateLimit      float64
+	RateBurst      int
+	MaxViolations  int
+	MaxMessageSize int64
+
+	Cert     string
+	Key      string
+	ClientCA string
+
+	AdminAddr       string
+	AdminToken      string
+	ShutdownTimeout time.Duration
+
+	LogFormat   string
+	LogLevel    string
+	LogPayloads bool
+}
+
+func defaultConfig() Config {
+	return Config{
+		Addr:            ":8080",
+		SnapshotEvery:   1000,
+		SendQueue:       256,
+		SlowClient:      slowClientResync,
+		PingInterval:    30 * time.Second,
+		PongTimeout:     60 * time.Second,
+		ResumeHistory:   1000,
+		RateLimit:       200,
+		RateBurst:       1000,
+		MaxViolations:   10,
+		MaxMessageSize:  4 << 20,
+		ShutdownTimeout: 10 * time.Second,
+		LogFormat:       "text",
+		LogLevel:        "info",
+	}
+}
+
+// bindConfig defines a flag for every setting, which sets the setting in config.
+func bindConfig(flags *flag.FlagSet, config *Config) {
+	flags.StringVar(&config.Addr, "addr", config.Addr, "Server address")
+	flags.StringVar(&config.DataDir, "data-dir", config.DataDir, "Directory to persist rooms in; rooms are kept in memory only if empty")
+	flags.IntVar(&config.SnapshotEvery, "snapshot-every", config.SnapshotEvery, "Number of logged operations after which a room is snapshotted and its log compacted")
+	flags.IntVar(&config.SendQueue, "send-queue", config.SendQueue, "Number of outbound messages buffered for each client")
+	flags.StringVar(&config.SlowClient, "slow-client", config.SlowClient, "What to do with a client whose send queue is full: \"resync\" drops its queued messages and sends it the whole document, \"disconnect\" disconnects it")
+	flags.StringVar(&config.TokenSecret, "token-secret", config.TokenSecret, "Secret that access tokens are signed with; if empty, no token is required")
+	flags.DurationVar(&config.PingInterval, "ping-interval", config.PingInterval, "How often clients are pinged")
+	flags.DurationVar(&config.PongTimeout, "pong-timeout", config.PongTimeout, "How long a client may go without answering a ping (or sending anything) before it is disconnected")
+	flags.IntVar(&config.ResumeHistory, "resume-history", config.ResumeHistory, "Number of recent changes kept per room, so reconnecting clients only get what they missed rather than the whole document")
+	flags.Float64Var(&config.RateLimit, "rate-limit", config.RateLimit, "Messages per second a client may send, after a burst of -rate-burst; faster messages are delayed (0 disables the limit)")
+	flags.IntVar(&config.RateBurst, "rate-burst", config.RateBurst, "Number of messages a client may send at once")
+	flags.IntVar(&config.MaxViolations, "max-violations", config.MaxViolations, "Number of consecutive seconds a client may spend over -rate-limit before it is disconnected")
+	flags.Int64Var(&config.MaxMessageSize, "max-message-size", config.MaxMessageSize, "Largest message, in bytes, a client may send (e.g. a docSync's document); larger ones disconnect it")
+	flags.StringVar(&config.Cert, "cert", config.Cert, "TLS certificate file; the server accepts wss:// connections if set, and reloads it when it changes")
+	flags.StringVar(&config.Key, "key", config.Key, "TLS private key file for -cert")
+	flags.StringVar(&config.ClientCA, "client-ca", config.ClientCA, "CA certificates file; if set, clients must present a TLS certificate signed by one of them")
+	flags.StringVar(&config.AdminAddr, "admin-addr", config.AdminAddr, "Address of the admin API's listener; the admin API is disabled if empty")
+	flags.StringVar(&config.AdminToken, "admin-token", config.AdminToken, "Token required by the admin API")
+	flags.DurationVar(&config.ShutdownTimeout, "shutdown-timeout", config.ShutdownTimeout, "How long the server may take to send clients their pending messages and persist rooms when it is stopped")
+	flags.StringVar(&config.LogFormat, "log-format", config.LogFormat, "Log format: \"text\" or \"json\"")
+	flags.StringVar(&config.LogLevel, "log-level", config.LogLevel, "Least severe level logged: \"debug\", \"info\", \"warn\" or \"error\"")
+	flags.BoolVar(&config.LogPayloads, "log-payloads", config.LogPayloads, "Include message content (operations' characters, documents, presences) in logs; it is left out by default, as it is user content")
+}
+
+// configSettings returns flags setting config, to set it by name.
+func configSettings(config *Config) *flag.FlagSet {
+	settings := flag.NewFlagSet("settings", flag.ContinueOnError)
+	settings.SetOutput(io.Discard)
+	bindConfig(settings, config)
+	return settings
+}
+
+// environmentVariable returns the name of the environment variable overriding a setting.
+func environmentVariable(setting string) string {
+	return environmentPrefix + strings.ToUpper(strings.ReplaceAll(setting, "-", "_"))
+}
+
+// loadConfig parses the server's command line, and returns the configuration it selects
+// and whether the configuration should only be printed (-print-config).
+func loadConfig(flags *flag.FlagSet, arguments []string, lookupEnvironment func(string) (string, bool)) (Config, bool, error) {
+	// Flags are parsed into parsed, and only those that were set are applied, over the file and environment.
+	parsed := defaultConfig()
+	bindConfig(flags, &parsed)
+	configFile := flags.String("config", "", "JSON configuration file, whose keys are the flags' names; every setting can also be set with an environment variable, e.g. $"+environmentVariable("data-dir")+" (default $"+environmentVariable("config")+")")
+	printConfig := flags.Bool("print-config", false, "Print the configuration, with secrets left out, check it and exit")
+	if err := flags.Parse(arguments); err != nil {
+		return Config{}, false, err
+	}
+
+	config := defaultConfig()
+	settings := configSettings(&config)
+	environmentConfigFile, _ := lookupEnvironment(environmentVariable("config"))
+	if path := cmp.Or(*configFile, environmentConfigFile); path != "" {
+		if err := readConfigFile(path, settings); err != nil {
+			return Config{}, false, err
+		}
+	}
+	var err error
+	settings.VisitAll(func(setting *flag.Flag) {
+		name := environmentVariable(setting.Name)
+		if value, ok := lookupEnvironment(name); ok && err == nil {
+			if setErr := settings.Set(setting.Name, value); setErr != nil {
+				err = fmt.Errorf("$%s: invalid value %q: %w", name, value, setErr)
+			}
+		}
+	})
+	flags.Visit(func(parsedFlag *flag.Flag) {
+		if settings.Lookup(parsedFlag.Name) != nil && err == nil {
+			err = settings.Set(parsedFlag.Name, parsedFlag.Value.String())
+		}
+	})
+	return config, *printConfig, err
+}
+
+// readConfigFile sets the settings in a JSON configuration file: an object whose keys are settings' names,
+// and whose values are strings (e.g. "30s" for durations), numbers or booleans.
+func readConfigFile(path string, settings *flag.FlagSet) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(content, &values); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if settings.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		var value any
+		if err := json.Unmarshal(values[name], &value); err != nil {
+			return fmt.Errorf("%s: %s: %w", path, name, err)
+		}
+		text := string(values[name])
+		switch value := value.(type) {
+		case string:
+			text = value
+		case float64, bool:
+		default:
+			return fmt.Errorf("%s: %s must be a string, number or boolean", path, name)
+		}
+		if err := settings.Set(name, text); err != nil {
+			return fmt.Errorf("%s: %s: invalid value %q: %w", path, name, text, err)
+		}
+	}
+	return nil
+}
+
+// validate checks the configuration, and returns every problem with it.
+func (config Config) validate() error {
+	var problems []error
+	check := func(ok bool, problem string) {
+		if !ok {
+			problems = append(problems, errors.New(problem))
+		}
+	}
+	check(config.SlowClient == slowClientResync || config.SlowClient == slowClientDisconnect, fmt.Sprintf("invalid slow-client policy %q", config.SlowClient))
+	// A joining client is sent its site ID and the room state at once.
+	check(config.SendQueue >= 2, "send-queue must be at least 2")
+	check(config.SnapshotEvery >= 0, "snapshot-every must not be negative")
+	check(config.ResumeHistory >= 0, "resume-history must not be negative")
+	check(config.RateLimit >= 0, "rate-limit must not be negative")
+	check(config.RateLimit == 0 || (config.RateBurst >= 1 && config.MaxViolations >= 1), "rate-burst and max-violations must be positive when rate-limit is set")
+	check(config.MaxMessageSize > 0, "max-message-size must be positive")
+	check(config.PingInterval > 0 && config.PongTimeout > config.PingInterval, "pong-timeout must be longer than ping-interval, which must be positive")
+	check(config.AdminAddr == "" || config.AdminToken != "", "admin-addr requires admin-token")
+	check(config.ShutdownTimeout > 0, "shutdown-timeout must be positive")
+	check((config.Cert == "") == (config.Key == ""), "cert and key must be set together")
+	check(config.ClientCA == "" || config.Cert != "", "client-ca requires cert")
+	if _, err := newLogger(io.Discard, config.LogFormat, config.LogLevel, false); err != nil {
+		problems = append(problems, err)
+	}
+	return errors.Join(problems...)
+}
+
+// print writes the configuration as a JSON configuration file, with secrets left out.
+func (config Config) print(writer io.Writer) error {
+	values := make(map[string]any)
+	configSettings(&config).VisitAll(func(setting *flag.Flag) {
+		value := setting.Value.(flag.Getter).Get()
+		if duration, ok := value.(time.Duration); ok {
+			value = duration.String()
+		}
+		if secretSettings[setting.Name] && value != "" {
+			value = "<redacted>"
+		}
+		values[setting.Name] = value
+	})
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(values)
+}
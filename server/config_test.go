@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// testLoadConfig loads the configuration selected by arguments and environment.
+func testLoadConfig(arguments []string, environment map[string]string) (Config, bool, error) {
+	flags := flag.NewFlagSet("coderpad-server", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	return loadConfig(flags, arguments, func(name string) (string, bool) {
+		value, ok := environment[name]
+		return value, ok
+	})
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "coderpad.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	return path
+}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	path := writeConfigFile(t, `{"addr": ":9000", "data-dir": "/var/lib/coderpad", "ping-interval": "5s", "pong-timeout": "20s", "rate-limit": 50, "log-payloads": true}`)
+	environment := map[string]string{
+		"CODERPAD_CONFIG":       path,
+		"CODERPAD_DATA_DIR":     "/srv/coderpad",
+		"CODERPAD_SEND_QUEUE":   "64",
+		"CODERPAD_TOKEN_SECRET": "from-environment",
+	}
+	got, printOnly, err := testLoadConfig([]string{"-send-queue", "32", "-print-config"}, environment)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if !printOnly {
+		t.Errorf("print-config mismatch; got = %v, expected = %v\n", printOnly, true)
+	}
+
+	expected := defaultConfig()
+	expected.Addr = ":9000"
+	expected.DataDir = "/srv/coderpad"
+	expected.PingInterval = 5 * time.Second
+	expected.PongTimeout = 20 * time.Second
+	expected.RateLimit = 50
+	expected.LogPayloads = true
+	expected.SendQueue = 32
+	expected.TokenSecret = "from-environment"
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("config mismatch (-expected +got):\n%s", diff)
+	}
+
+	// A flag given the default value still overrides the file.
+	got, _, err = testLoadConfig([]string{"-config", path, "-addr", ":8080"}, nil)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if got.Addr != ":8080" {
+		t.Errorf("addr mismatch; got = %v, expected = %v\n", got.Addr, ":8080")
+	}
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        string
+		environment map[string]string
+		expected    string
+	}{
+		{"unknown setting", `{"adress": ":9000"}`, nil, `unknown setting "adress"`},
+		{"invalid value", `{"ping-interval": 5}`, nil, `ping-interval: invalid value "5"`},
+		{"object value", `{"addr": {"port": 9000}}`, nil, "addr must be a string, number or boolean"},
+		{"malformed file", `{"addr": `, nil, "unexpected end of JSON input"},
+		{"invalid environment", `{}`, map[string]string{"CODERPAD_RATE_BURST": "many"}, `$CODERPAD_RATE_BURST: invalid value "many"`},
+	}
+	for _, test := range tests {
+		_, _, err := testLoadConfig([]string{"-config", writeConfigFile(t, test.file)}, test.environment)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("error mismatch for %s; got = %v, expected = %v\n", test.name, err, test.expected)
+		}
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	if err := defaultConfig().validate(); err != nil {
+		t.Errorf("default config is invalid: %v\n", err)
+	}
+
+	config := defaultConfig()
+	config.SlowClient = "ignore"
+	config.SendQueue = 1
+	config.PongTimeout = config.PingInterval
+	config.AdminAddr = ":8081"
+	config.ClientCA = "ca.pem"
+	config.LogLevel = "loud"
+	err := config.validate()
+	if err == nil {
+		t.Fatalf("invalid config was accepted\n")
+	}
+	for _, problem := range []string{"slow-client", "send-queue", "pong-timeout", "admin-addr", "client-ca", "log level"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("problem missing; got = %v, expected = %v\n", err, problem)
+		}
+	}
+}
+
+func TestConfig_Print(t *testing.T) {
+	config := defaultConfig()
+	config.TokenSecret = "token secret"
+	config.AdminToken = "admin token"
+	config.PingInterval = 15 * time.Second
+	var output bytes.Buffer
+	if err := config.print(&output); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if strings.Contains(output.String(), "token secret") || strings.Contains(output.String(), "admin token") {
+		t.Errorf("secrets printed: %s\n", output.String())
+	}
+
+	var printed map[string]any
+	if err := json.Unmarshal(output.Bytes(), &printed); err != nil {
+		t.Fatalf("decode error: %v\n", err)
+	}
+	if printed["ping-interval"] != "15s" || printed["send-queue"] != float64(256) || printed["token-secret"] != "<redacted>" {
+		t.Errorf("printed config mismatch; got = %v\n", printed)
+	}
+
+	// The printed configuration, without its secrets, can be read back as a configuration file.
+	delete(printed, "token-secret")
+	delete(printed, "admin-token")
+	content, _ := json.Marshal(printed)
+	got, _, err := testLoadConfig([]string{"-config", writeConfigFile(t, string(content))}, nil)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	config.TokenSecret, config.AdminToken = "", ""
+	if diff := cmp.Diff(config, got); diff != "" {
+		t.Errorf("config mismatch (-expected +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/omesh-barhate/coderpad/commons"
+	"github.com/omesh-barhate/coderpad/crdt"
+)
+
+// The content API lets tools read and write pads without a websocket client, with the same access tokens:
+//   - GET /rooms/{room}/content returns the document as plain text;
+//   - PUT /rooms/{room}/content replaces it with the request body, which requires the editor role;
+//   - GET /rooms/{room}/snapshot downloads the room's document and metadata, in the format of the data directory's snapshots.
+//
+// A PUT is applied as the character edits turning the current text into the new one, so clients editing the pad
+// at the same time keep their changes. The edits are planned outside the hub goroutine, against a snapshot of the
+// document, and anchored to its characters' IDs, so they still apply if the document changed since.
+// The result is persisted and sent to the room's clients as a single docSync.
+
+const (
+	// maxContentSize is the largest document a PUT may send.
+	maxContentSize = 1 << 20
+	// contentAuthor is the author of the changes made through the content API.
+	contentAuthor = "api"
+	// contentAttempts is how many times a PUT is planned again if the document is replaced while it is planned.
+	contentAttempts = 3
+)
+
+// withContentRoom checks the request's room and token, and runs function on the hub goroutine with the room.
+// If editing is set, the token must grant the editor role.
+// It reports whether function succeeded; if not, it has already responded with an error.
+func withContentRoom(hub *Hub, response http.ResponseWriter, request *http.Request, editing bool, function func(*Room) error) bool {
+	roomID := request.PathValue("room")
+	if !roomIDPattern.MatchString(roomID) {
+		http.Error(response, "invalid room", http.StatusNotFound)
+		return false
+	}
+	claims, err := authorize(request, roomID)
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	if editing && claims.Role == commons.RoleViewer {
+		http.Error(response, "viewers can't change the pad", http.StatusForbidden)
+		return false
+	}
+	hub.call(func() {
+		var room *Room
+		if room, err = hub.getRoom(roomID); err == nil {
+			err = function(room)
+		}
+	})
+	if err != nil {
+		requestLogger(request).Error("content API error", slog.String("room", roomID), slog.Any("error", err))
+		http.Error(response, "room unavailable", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+func handleGetContent(hub *Hub, response http.ResponseWriter, request *http.Request) {
+	var content string
+	if withContentRoom(hub, response, request, false, func(room *Room) error {
+		content = room.document.Content()
+		return nil
+	}) {
+		response.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.WriteString(response, content)
+	}
+}
+
+func handlePutContent(hub *Hub, response http.ResponseWriter, request *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(response, request.Body, maxContentSize))
+	if err != nil {
+		http.Error(response, fmt.Sprintf("content must be at most %d bytes", maxContentSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !utf8.Valid(body) {
+		http.Error(response, "content must be UTF-8 text", http.StatusBadRequest)
+		return
+	}
+	for attempt := 0; attempt < contentAttempts; attempt++ {
+		var snapshot crdt.Document
+		if !withContentRoom(hub, response, request, true, func(room *Room) error {
+			snapshot = room.document.Snapshot()
+			return nil
+		}) {
+			return
+		}
+		edits, err := planContent(snapshot, string(body))
+		if err != nil {
+			requestLogger(request).Error("failed to plan the content", slog.Any("error", err))
+			http.Error(response, "failed to apply the content", http.StatusInternalServerError)
+			return
+		}
+		if len(edits) == 0 {
+			response.WriteHeader(http.StatusNoContent)
+			return
+		}
+		applied := false
+		if !withContentRoom(hub, response, request, true, func(room *Room) error {
+			message, ok, err := room.setContent(edits, contentAuthor)
+			if !ok {
+				return nil
+			}
+			applied = true
+			hub.broadcast(room, nil, message)
+			requestLogger(request).Info("content replaced", slog.String("room", room.ID), slog.Int("edits", len(edits)))
+			return err
+		}) {
+			return
+		}
+		if applied {
+			response.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	http.Error(response, "the pad was replaced while the content was applied, try again", http.StatusConflict)
+}
+
+// contentEdit is an edit planned by planContent: the deletion of a character of the document, or the insertion
+// of a character whose ID is a placeholder, as may be its neighbours' if they were inserted before it.
+type contentEdit struct {
+	deleted  string
+	inserted *crdt.Character
+}
+
+// planContent returns the edits turning document's content into content, anchored to document's characters.
+func planContent(document crdt.Document, content string) ([]contentEdit, error) {
+	scratch := crdt.NewSyncedDocument(document)
+	var edits []contentEdit
+	for i, edit := range crdt.Edits(crdt.Content(document), content) {
+		switch edit.Type {
+		case crdt.ChangeInsert:
+			// Placeholders have no ".", so they can't be the ID of one of the document's characters.
+			character, err := scratch.InsertWithID(edit.Position, edit.Value, strconv.Itoa(i), contentAuthor)
+			if err != nil {
+				return nil, err
+			}
+			edits = append(edits, contentEdit{inserted: &character})
+		case crdt.ChangeDelete:
+			if character, ok := scratch.Delete(edit.Position, contentAuthor); ok {
+				edits = append(edits, contentEdit{deleted: character.ID})
+			}
+		}
+	}
+	return edits, nil
+}
+
+func handleGetSnapshot(hub *Hub, response http.ResponseWriter, request *http.Request) {
+	var snapshot roomSnapshot
+	var roomID string
+	if !withContentRoom(hub, response, request, false, func(room *Room) error {
+		roomID = room.ID
+		snapshot = roomSnapshot{Sequence: room.sequence, Clock: room.clock, Document: room.document.Snapshot(), Metadata: room.metadata.Clone()}
+		return nil
+	}) {
+		return
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", roomID+".json"))
+	if err := json.NewEncoder(response).Encode(snapshot); err != nil {
+		requestLogger(request).Warn("failed to write the snapshot", slog.String("room", roomID), slog.Any("error", err))
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/omesh-barhate/coderpad/commons"
+	"github.com/omesh-barhate/coderpad/crdt"
+)
+
+func contentRequest(t *testing.T, method, url, token, body string) (int, string) {
+	t.Helper()
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	defer response.Body.Close()
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	return response.StatusCode, string(content)
+}
+
+func TestContentAPI(t *testing.T) {
+	server, _ := newTestServer(t)
+	path := uniqueRoom("content")
+	url := server.URL + "/rooms/" + strings.TrimPrefix(path, "/pad/")
+
+	if status, _ := contentRequest(t, http.MethodPut, url+"/content", "", "question\nanswer here\n"); status != http.StatusNoContent {
+		t.Fatalf("status mismatch; got = %v, expected = %v\n", status, http.StatusNoContent)
+	}
+	alice, _ := dial(t, server, path)
+	replica := crdt.NewSyncedDocument(readUntil(t, alice, commons.DocSyncMessage).Document)
+
+	// Only the answer changes, and a client in the room receives the result as one docSync.
+	if status, _ := contentRequest(t, http.MethodPut, url+"/content", "", "question\nfinal answer\n"); status != http.StatusNoContent {
+		t.Fatalf("status mismatch; got = %v, expected = %v\n", status, http.StatusNoContent)
+	}
+	message := readUntil(t, alice, commons.DocSyncMessage)
+	if message.Username != contentAuthor {
+		t.Errorf("author mismatch; got = %v, expected = %v\n", message.Username, contentAuthor)
+	}
+	question := crdt.IthVisible(replica.Snapshot(), 1).ID
+	replica.Replace(message.Document, message.Username)
+	if got := crdt.IthVisible(replica.Snapshot(), 1).ID; got != question {
+		t.Errorf("unchanged character's ID mismatch; got = %v, expected = %v\n", got, question)
+	}
+	if _, content := contentRequest(t, http.MethodGet, url+"/content", "", ""); content != "question\nfinal answer\n" {
+		t.Errorf("content mismatch; got = %q, expected = %q\n", content, "question\nfinal answer\n")
+	}
+
+	status, body := contentRequest(t, http.MethodGet, url+"/snapshot", "", "")
+	var snapshot roomSnapshot
+	if err := json.Unmarshal([]byte(body), &snapshot); status != http.StatusOK || err != nil {
+		t.Fatalf("snapshot error: %v %v\n", status, err)
+	}
+	if crdt.Content(snapshot.Document) != replica.Content() || snapshot.Sequence == 0 {
+		t.Errorf("snapshot mismatch; got = %q at %d\n", crdt.Content(snapshot.Document), snapshot.Sequence)
+	}
+}
+
+func TestContentAPI_Token(t *testing.T) {
+	previous := config.TokenSecret
+	config.TokenSecret = "secret"
+	t.Cleanup(func() { config.TokenSecret = previous })
+	server, _ := newTestServer(t)
+	url := server.URL + "/rooms/guarded/content"
+	expires := time.Now().Add(time.Minute).Unix()
+	editor, _ := signToken([]byte(config.TokenSecret), tokenClaims{Room: "guarded", ExpiresAt: expires})
+	viewer, _ := signToken([]byte(config.TokenSecret), tokenClaims{Room: "guarded", ExpiresAt: expires, Role: commons.RoleViewer})
+
+	for _, test := range []struct {
+		method, token string
+		expected      int
+	}{
+		{http.MethodGet, "", http.StatusUnauthorized},
+		{http.MethodPut, viewer, http.StatusForbidden},
+		{http.MethodPut, editor, http.StatusNoContent},
+		{http.MethodGet, viewer, http.StatusOK},
+	} {
+		if status, _ := contentRequest(t, test.method, url, test.token, "text"); status != test.expected {
+			t.Errorf("%s status mismatch; got = %v, expected = %v\n", test.method, status, test.expected)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/omesh-barhate/coderpad/commons"
+	"github.com/omesh-barhate/coderpad/crdt"
+)
+
+// Reasons a client was disconnected, as counted in hubStats.
+const (
+	disconnectClosed       = "closed"
+	disconnectSlowConsumer = "slow_consumer"
+	disconnectTimeout      = "timeout"
+	disconnectShutdown     = "shutdown"
+	disconnectKicked       = "kicked"
+	disconnectRoomClosed   = "room_closed"
+	disconnectRateLimited  = "rate_limited"
+	disconnectTooLarge     = "too_large"
+)
+
+// Close reasons sent to clients disconnected by the server.
+const (
+	shutdownReason    = "server restarting"
+	kickedReason      = "kicked by an administrator"
+	roomClosedReason  = "room closed by an administrator"
+	rateLimitedReason = "too many messages"
+	tooLargeReason    = "message too large"
+)
+
+// Policies for a client whose send queue is full.
+const (
+	// slowClientResync drops the client's queued messages and sends it the room state instead.
+	slowClientResync = "resync"
+	// slowClientDisconnect disconnects the client.
+	slowClientDisconnect = "disconnect"
+)
+
+// departure is a client leaving the hub, and why.
+type departure struct {
+	client *Client
+	reason string
+}
+
+// inboundMessage is a message received from a client.
+type inboundMessage struct {
+	client  *Client
+	message commons.Message
+}
+
+// Hub owns every room and client. All of its state is only touched by the run goroutine;
+// connections talk to it through the register, unregister and inbound channels,
+// and other goroutines can run code on it with call.
+type Hub struct {
+	rooms map[string]*Room
+
+	register   chan *Client
+	unregister chan departure
+	inbound    chan inboundMessage
+	calls      chan func()
+
+	// closing is set once the hub is shut down, after which clients are turned away.
+	closing bool
+
+	stats hubStats
+	// bytesIn, bytesOut and broadcastLatency are updated by the clients' pumps rather than the run goroutine.
+	bytesIn, bytesOut atomic.Uint64
+	broadcastLatency  histogram
+}
+
+func newHub() *Hub {
+	return &Hub{
+		rooms:      make(map[string]*Room),
+		register:   make(chan *Client),
+		unregister: make(chan departure),
+		inbound:    make(chan inboundMessage),
+		calls:      make(chan func()),
+		stats:      hubStats{Disconnects: make(map[string]int), Messages: make(map[string]int), Operations: make(map[string]int)},
+	}
+}
+
+func (hub *Hub) run() {
+	for {
+		select {
+		case client := <-hub.register:
+			hub.handleRegister(client)
+		case leaving := <-hub.unregister:
+			hub.disconnect(leaving.client, leaving.reason)
+		case inbound := <-hub.inbound:
+			hub.handleInbound(inbound.client, inbound.message)
+		case function := <-hub.calls:
+			function()
+		}
+	}
+}
+
+// call runs function on the hub goroutine and waits for it to return.
+func (hub *Hub) call(function func()) {
+	done := make(chan struct{})
+	hub.calls <- func() {
+		function()
+		close(done)
+	}
+	<-done
+}
+
+// room returns the room with the given ID, creating (or restoring) it if needed.
+func (hub *Hub) room(roomID string) (*Room, error) {
+	var room *Room
+	var err error
+	hub.call(func() {
+		room, err = hub.getRoom(roomID)
+	})
+	return room, err
+}
+
+// getRoom must only be called on the hub goroutine, or before it starts.
+func (hub *Hub) getRoom(roomID string) (*Room, error) {
+	if room, ok := hub.rooms[roomID]; ok {
+		return room, nil
+	}
+	room, err := newRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+	hub.rooms[roomID] = room
+	return room, nil
+}
+
+// restoreRooms loads every room persisted in the data directory. It must be called before run.
+func (hub *Hub) restoreRooms() error {
+	if config.DataDir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(config.DataDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !roomIDPattern.MatchString(entry.Name()) {
+			continue
+		}
+		if _, err := hub.getRoom(entry.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (hub *Hub) handleRegister(client *Client) {
+	room := client.room
+	if hub.closing {
+		turnAway(client, websocket.CloseServiceRestart, shutdownReason)
+		return
+	}
+	if hub.rooms[room.ID] != room {
+		// The room was closed while the client was connecting.
+		turnAway(client, commons.CloseRoomClosed, roomClosedReason)
+		return
+	}
+	siteID, err := room.issueSiteID(client.session)
+	if err != nil {
+		client.logger.Error("failed to persist the site ID", slog.Any("error", err))
+	}
+	client.SiteID = siteID
+	room.clients[client.ID] = client
+	client.logger.Info("client registered", slog.String("site", client.SiteID), slog.String("role", string(client.Role)), slog.Int("clients", len(room.clients)))
+
+	// A resumed site continues from its clock, so its new characters' IDs don't collide with its earlier ones.
+	site, _ := strconv.Atoi(siteID)
+	clock := crdt.MaxClock(room.document.Snapshot(), site)
+	hub.send(client, commons.Message{MessageType: commons.SiteIDMessage, Text: client.SiteID, ClientID: client.ID, Role: client.Role, Clock: clock})
+	// A resuming client only needs the changes it missed, if the room still has them and they fit in its queue.
+	if missed, ok := room.since(client.resumeFrom); client.resuming && ok && len(missed) <= cap(client.send)-2 {
+		client.logger.Info("resuming client", slog.Uint64("from", client.resumeFrom), slog.Int("missed", len(missed)))
+		for _, message := range missed {
+			hub.send(client, message)
+		}
+	} else {
+		state := room.state()
+		client.logger.Debug("sending room state", slog.Int("characters", len(state.Document.Characters)))
+		hub.send(client, state)
+	}
+	for _, other := range room.clients {
+		if other.presence != nil {
+			hub.send(client, *other.presence)
+		}
+	}
+}
+
+// disconnect removes a client from its room and closes its send queue, which closes the connection.
+func (hub *Hub) disconnect(client *Client, reason string) {
+	room := client.room
+	if room.clients[client.ID] != client {
+		return
+	}
+	client.logger.Info("client disconnected", slog.String("username", client.Username), slog.String("reason", reason))
+	delete(room.clients, client.ID)
+	close(client.send)
+	hub.stats.Disconnects[reason]++
+	hub.broadcast(room, client, commons.Message{MessageType: commons.LeaveMessage, Username: client.Username, ClientID: client.ID})
+}
+
+// turnAway closes the connection of a client that wasn't registered.
+func turnAway(client *Client, code int, text string) {
+	client.closeCode, client.closeReason = code, text
+	close(client.send)
+}
+
+func errorMessage(code commons.ErrorCode, text string) commons.Message {
+	return commons.Message{MessageType: commons.ErrorMessage, Error: &commons.Error{Code: code, Text: text}}
+}
+
+// notify queues a message for a client from another goroutine, unless the client was disconnected.
+func (hub *Hub) notify(client *Client, message commons.Message) {
+	hub.call(func() {
+		if client.room.clients[client.ID] == client {
+			hub.send(client, message)
+		}
+	})
+}
+
+// kick disconnects a client, telling it why in the close message.
+func (hub *Hub) kick(client *Client, reason string, code int, text string) {
+	if client.room.clients[client.ID] != client {
+		return
+	}
+	client.closeCode, client.closeReason = code, text
+	hub.disconnect(client, reason)
+}
+
+// closeRoom disconnects every client of a room, persists it and unloads it.
+// Clients connecting to it later get a new room, restored from the data directory if it is persisted.
+func (hub *Hub) closeRoom(room *Room) error {
+	delete(hub.rooms, room.ID)
+	for _, client := range room.clients {
+		hub.kick(client, disconnectRoomClosed, commons.CloseRoomClosed, roomClosedReason)
+	}
+	return room.close()
+}
+
+// shutdown turns every client away: it closes their connections, with the reason shutdownReason,
+// once the messages already queued for them are sent. It then persists every room.
+// The returned channel is closed once every connection is closed.
+func (hub *Hub) shutdown() <-chan struct{} {
+	var pending []chan struct{}
+	hub.call(func() {
+		hub.closing = true
+		for _, room := range hub.rooms {
+			for _, client := range room.clients {
+				delete(room.clients, client.ID)
+				client.closeCode, client.closeReason = websocket.CloseServiceRestart, shutdownReason
+				close(client.send)
+				hub.stats.Disconnects[disconnectShutdown]++
+				pending = append(pending, client.done)
+			}
+			if err := room.close(); err != nil {
+				slog.Error("failed to persist room", slog.String("room", room.ID), slog.Any("error", err))
+			}
+		}
+	})
+	flushed := make(chan struct{})
+	go func() {
+		for _, done := range pending {
+			<-done
+		}
+		close(flushed)
+	}()
+	return flushed
+}
+
+func (hub *Hub) handleInbound(client *Client, message commons.Message) {
+	hub.stats.Messages[messageLabel(message.MessageType)]++
+	if message.MessageType == "operation" {
+		hub.stats.Operations[operationLabel(message.Operation.OperationType)]++
+	}
+	room := client.room
+	if room.clients[client.ID] != client {
+		// The client was disconnected while this message was in flight.
+		return
+	}
+	if message.MessageType == commons.StateReqMessage {
+		hub.send(client, room.state())
+		return
+	}
+	// The change's ID is only meant for the server's answer, not for the replica or other clients.
+	changeID := message.ChangeID
+	message.ChangeID = 0
+	if client.Role == commons.RoleViewer && changesState(message) {
+		client.logger.Warn("dropped change from viewer", messageAttr(message))
+		hub.answer(client, changeID, errorMessage(commons.ErrorForbidden, fmt.Sprintf("viewers can't send %s messages", message.MessageType)))
+		return
+	}
+	message, err := room.apply(message)
+	if errors.Is(err, errRejected) {
+		// The server's replica is the source of truth, so a change it couldn't apply isn't acknowledged or forwarded.
+		client.logger.Warn("rejected message", messageAttr(message), slog.Any("error", err))
+		code := commons.ErrorInvalidMessage
+		if message.MessageType == "operation" {
+			code = commons.ErrorInvalidOperation
+		}
+		hub.answer(client, changeID, errorMessage(code, fmt.Sprintf("%s: %v", message.MessageType, err)))
+		return
+	}
+	if err != nil {
+		client.logger.Error("failed to persist message", messageAttr(message), slog.Any("error", err))
+	}
+	if changesState(message) {
+		hub.answer(client, changeID, commons.Message{MessageType: commons.AckMessage, Sequence: message.Sequence})
+	}
+	switch message.MessageType {
+	case commons.JoinMessage:
+		client.Username = message.Username
+		client.logger.Info("client joined", slog.String("username", message.Username))
+	case commons.PresenceMessage:
+		client.presence = &message
+	}
+	client.logger.Debug("message received", messageAttr(message))
+	hub.broadcast(room, client, message)
+}
+
+// answer queues the ack or error answering a client's change.
+func (hub *Hub) answer(client *Client, changeID uint64, message commons.Message) {
+	message.ChangeID = changeID
+	// Validation errors are answered from readPump, so answers can arrive out of order.
+	client.lastChange = max(client.lastChange, changeID)
+	hub.send(client, message)
+}
+
+// broadcast queues a message for every client in the room except the sender.
+func (hub *Hub) broadcast(room *Room, sender *Client, message commons.Message) {
+	for _, client := range room.clients {
+		if client != sender {
+			hub.send(client, message)
+		}
+	}
+}
+
+// send queues a message for a client without blocking the hub.
+// If the client's queue is full, it is handled according to config.SlowClient.
+// A client that was disconnected, possibly by an earlier send, is skipped, as its queue is closed.
+func (hub *Hub) send(client *Client, message commons.Message) {
+	if client.room.clients[client.ID] != client {
+		return
+	}
+	select {
+	case client.send <- outboundMessage{message: message, queued: time.Now()}:
+		return
+	default:
+	}
+	if config.SlowClient == slowClientResync && hub.resync(client) {
+		return
+	}
+	hub.disconnect(client, disconnectSlowConsumer)
+}
+
+// resync replaces every message queued for a client with the room state, which supersedes them.
+// Messages that aren't reflected in the state (e.g. join notices) are lost.
+func (hub *Hub) resync(client *Client) bool {
+	for drained := false; !drained; {
+		select {
+		case <-client.send:
+			hub.stats.DroppedMessages++
+		default:
+			drained = true
+		}
+	}
+	// The state acknowledges the client's changes, whose answers may have been drained.
+	state := client.room.state()
+	state.ChangeID = client.lastChange
+	select {
+	case client.send <- outboundMessage{message: state, queued: time.Now()}:
+		client.logger.Warn("send queue full, resyncing")
+		hub.stats.Resyncs++
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,586 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/omesh-barhate/coderpad/commons"
+	"github.com/omesh-barhate/coderpad/crdt"
+)
+
+func TestHub_ConcurrentClients(t *testing.T) {
+	const clients, operations = 20, 20
+	// Every client sends its operations at once, so the hub can queue all the others' (clients-1)*operations = 380
+	// for a client before its writePump catches up: queuing a message is a channel send, while the pump marshals and writes it.
+	// The default queue of 256 is sized for people typing, not for this burst, which would get clients disconnected
+	// as slow consumers; the queue is sized for the burst so only a client that stops reading would be.
+	previousSize := config.SendQueue
+	config.SendQueue = 2 + clients*operations
+	t.Cleanup(func() { config.SendQueue = previousSize })
+	server, hub := newTestServer(t)
+	path := uniqueRoom("concurrent")
+
+	connections := make([]*websocket.Conn, clients)
+	siteIDs := make([]string, clients)
+	for i := range connections {
+		connection, siteIDMessage := dial(t, server, path)
+		readUntil(t, connection, commons.DocSyncMessage)
+		connections[i], siteIDs[i] = connection, siteIDMessage.Text
+	}
+
+	var wait sync.WaitGroup
+	errs := make(chan error, 2*clients)
+	for i, connection := range connections {
+		wait.Add(2)
+		go func() {
+			defer wait.Done()
+			// Each client appends its own characters after the previous one, so every operation integrates on every replica.
+			previous := crdt.StartCharacter.ID
+			for n := 1; n <= operations; n++ {
+				character := crdt.Character{ID: fmt.Sprintf("%s.%d", siteIDs[i], n), Visible: true, Value: "x", PrevID: previous, NextID: crdt.EndCharacter.ID}
+				operation := commons.Message{MessageType: "operation", Operation: commons.Operation{OperationType: "insert", Value: "x", Character: &character}}
+				if err := connection.WriteJSON(&operation); err != nil {
+					errs <- err
+					return
+				}
+				previous = character.ID
+			}
+		}()
+		go func() {
+			defer wait.Done()
+			_ = connection.SetReadDeadline(time.Now().Add(10 * time.Second))
+			for received := 0; received < (clients-1)*operations; {
+				var message commons.Message
+				if err := connection.ReadJSON(&message); err != nil {
+					errs <- fmt.Errorf("client %s got %d operations: %w", siteIDs[i], received, err)
+					return
+				}
+				if message.MessageType == "operation" {
+					received++
+				}
+			}
+		}()
+	}
+	wait.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	room, err := hub.room(strings.TrimPrefix(path, "/pad/"))
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	var content string
+	hub.call(func() { content = room.document.Content() })
+	if got, want := len(content), clients*operations; got != want {
+		t.Errorf("replica length mismatch; got = %v, expected = %v\n", got, want)
+	}
+}
+
+// slowClient registers a client, which never reads its send queue, with a hub running the given slow client policy.
+func slowClient(t *testing.T, policy string) (*Hub, *Client) {
+	t.Helper()
+	previousPolicy, previousSize := config.SlowClient, config.SendQueue
+	config.SlowClient, config.SendQueue = policy, 3
+	t.Cleanup(func() { config.SlowClient, config.SendQueue = previousPolicy, previousSize })
+
+	hub := newHub()
+	room, err := hub.getRoom("slow-" + policy)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	client := newClient(room, nil, commons.RoleEditor, slog.Default())
+	hub.handleRegister(client)
+	go hub.run()
+	return hub, client
+}
+
+func TestHub_SlowClientResync(t *testing.T) {
+	hub, client := slowClient(t, slowClientResync)
+	hub.call(func() {
+		for i := 1; i <= 2; i++ {
+			message := insertMessage(i, "x")
+			if _, err := client.room.apply(message); err != nil {
+				t.Errorf("error: %v\n", err)
+			}
+			hub.broadcast(client.room, nil, message)
+		}
+	})
+
+	stats := hub.snapshot()
+	if stats.Resyncs != 1 || stats.DroppedMessages != 3 || stats.QueueDepth != 1 {
+		t.Errorf("stats mismatch; got = %+v\n", stats)
+	}
+	if state := (<-client.send).message; state.MessageType != commons.DocSyncMessage || crdt.Content(state.Document) != "xx" {
+		t.Errorf("expected the room state after a resync; got %+v\n", state)
+	}
+}
+
+func TestHub_ResyncAcknowledgesChanges(t *testing.T) {
+	hub, client := slowClient(t, slowClientResync)
+	hub.call(func() {
+		// The second ack overflows the queue, which is resynced.
+		hub.answer(client, 7, commons.Message{MessageType: commons.AckMessage, Sequence: 1})
+		hub.answer(client, 8, commons.Message{MessageType: commons.AckMessage, Sequence: 2})
+	})
+	if state := (<-client.send).message; state.MessageType != commons.DocSyncMessage || state.ChangeID != 8 {
+		t.Errorf("acknowledged change mismatch; got = %+v, expected = %v\n", state, 8)
+	}
+}
+
+func TestHub_SlowClientDisconnect(t *testing.T) {
+	hub, client := slowClient(t, slowClientDisconnect)
+	hub.call(func() {
+		hub.send(client, insertMessage(1, "x"))
+		hub.send(client, insertMessage(2, "x"))
+	})
+
+	stats := hub.snapshot()
+	if stats.Disconnects[disconnectSlowConsumer] != 1 || stats.QueueDepth != 0 {
+		t.Errorf("stats mismatch; got = %+v\n", stats)
+	}
+	queued := 0
+	for range client.send {
+		queued++
+	}
+	if queued != 3 {
+		t.Errorf("queued messages mismatch; got = %v, expected = %v\n", queued, 3)
+	}
+}
+
+func TestHub_SlowClientDisconnectedWhileRegistering(t *testing.T) {
+	hub, client := slowClient(t, slowClientDisconnect)
+	joining := newClient(client.room, nil, commons.RoleEditor, slog.Default())
+	hub.call(func() {
+		// The joining client is sent its site ID, the room state and three presences, which overflow its queue.
+		for i := 0; i < 3; i++ {
+			other := newClient(client.room, nil, commons.RoleEditor, slog.Default())
+			other.presence = &commons.Message{MessageType: commons.PresenceMessage, Presence: &commons.Presence{}}
+			client.room.clients[other.ID] = other
+		}
+		hub.handleRegister(joining)
+	})
+
+	if stats := hub.snapshot(); stats.Disconnects[disconnectSlowConsumer] != 1 {
+		t.Errorf("stats mismatch; got = %+v\n", stats)
+	}
+	queued := 0
+	for range joining.send {
+		queued++
+	}
+	if queued != 3 {
+		t.Errorf("queued messages mismatch; got = %v, expected = %v\n", queued, 3)
+	}
+}
+
+func TestHub_ViewerCannotEdit(t *testing.T) {
+	server, _ := newTestServer(t)
+	path := uniqueRoom("viewers")
+	viewer, viewerSite := dial(t, server, path+"?role=viewer")
+	editor, editorSite := dial(t, server, path)
+	readUntil(t, viewer, commons.DocSyncMessage)
+	readUntil(t, editor, commons.DocSyncMessage)
+	if viewerSite.Role != commons.RoleViewer || editorSite.Role != commons.RoleEditor {
+		t.Errorf("role mismatch; got = %v and %v, expected = %v and %v\n", viewerSite.Role, editorSite.Role, commons.RoleViewer, commons.RoleEditor)
+	}
+
+	for _, message := range []commons.Message{
+		{MessageType: commons.DocSyncMessage, Document: crdt.New()},
+		insertMessage(1, "v"),
+	} {
+		if err := viewer.WriteJSON(&message); err != nil {
+			t.Fatalf("write error: %v\n", err)
+		}
+	}
+	if err := viewer.WriteJSON(&commons.Message{MessageType: commons.JoinMessage, Username: "viewer"}); err != nil {
+		t.Fatalf("write error: %v\n", err)
+	}
+	// Messages from a client are handled in order, so the viewer's edits would have arrived before its join.
+	if got := readMessage(t, editor); got.MessageType != commons.JoinMessage {
+		t.Errorf("expected only the viewer's join; got %+v\n", got)
+	}
+	if got := readUntil(t, viewer, commons.ErrorMessage); got.Error == nil || got.Error.Code != commons.ErrorForbidden {
+		t.Errorf("expected a forbidden error; got %+v\n", got)
+	}
+
+	message := insertMessage(1, "e")
+	if err := editor.WriteJSON(&message); err != nil {
+		t.Fatalf("write error: %v\n", err)
+	}
+	if got := readUntil(t, viewer, "operation"); got.Operation.Value != "e" {
+		t.Errorf("operation mismatch; got = %+v, expected = %+v\n", got.Operation, message.Operation)
+	}
+}
+
+func TestHub_PresenceAndLeave(t *testing.T) {
+	server, _ := newTestServer(t)
+	path := uniqueRoom("presence")
+	alice, _ := dial(t, server, path)
+	readUntil(t, alice, commons.DocSyncMessage)
+	presence := commons.Presence{Cursor: crdt.StartCharacter.ID, Color: 2}
+	for _, message := range []commons.Message{
+		{MessageType: commons.JoinMessage, Username: "alice"},
+		{MessageType: commons.PresenceMessage, Username: "alice", Presence: &presence},
+	} {
+		if err := alice.WriteJSON(&message); err != nil {
+			t.Fatalf("write error: %v\n", err)
+		}
+	}
+
+	// The joiner gets alice's latest presence right after the room state.
+	var got commons.Message
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline) && got.Presence == nil; time.Sleep(10 * time.Millisecond) {
+		bob, _ := dial(t, server, path)
+		readUntil(t, bob, commons.DocSyncMessage)
+		_ = bob.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_ = bob.ReadJSON(&got)
+		bob.Close()
+	}
+	if got.Presence == nil || *got.Presence != presence || got.Username != "alice" {
+		t.Errorf("presence mismatch; got = %+v, expected = %+v\n", got.Presence, presence)
+	}
+
+	carol, _ := dial(t, server, path)
+	alice.Close()
+	// The probing connections above may leave after carol joins, too.
+	for readUntil(t, carol, commons.LeaveMessage).Username != "alice" {
+	}
+}
+
+func TestHub_EvictsDeadClients(t *testing.T) {
+	previousInterval, previousTimeout := config.PingInterval, config.PongTimeout
+	config.PingInterval, config.PongTimeout = 20*time.Millisecond, 200*time.Millisecond
+	t.Cleanup(func() { config.PingInterval, config.PongTimeout = previousInterval, previousTimeout })
+	server, hub := newTestServer(t)
+	path := uniqueRoom("heartbeat")
+
+	// A client that stops reading never answers pings, like one whose network went away.
+	dead, _ := dial(t, server, path)
+	if err := dead.WriteJSON(&commons.Message{MessageType: commons.JoinMessage, Username: "dead"}); err != nil {
+		t.Fatalf("write error: %v\n", err)
+	}
+	alive, _ := dial(t, server, path)
+	// Reading answers pings, so this client stays connected for several timeouts.
+	if leave := readUntil(t, alive, commons.LeaveMessage); leave.Username != "dead" {
+		t.Errorf("username mismatch; got = %v, expected = %v\n", leave.Username, "dead")
+	}
+	_ = alive.SetReadDeadline(time.Now().Add(3 * config.PongTimeout))
+	var message commons.Message
+	if err := alive.ReadJSON(&message); err == nil {
+		t.Errorf("unexpected message %+v\n", message)
+	}
+
+	stats := hub.snapshot()
+	if stats.Disconnects[disconnectTimeout] != 1 {
+		t.Errorf("timeouts mismatch; got = %v, expected = %v\n", stats.Disconnects[disconnectTimeout], 1)
+	}
+	var clients int
+	hub.call(func() { clients = len(hub.rooms[strings.TrimPrefix(path, "/pad/")].clients) })
+	if clients != 1 {
+		t.Errorf("clients mismatch; got = %v, expected = %v\n", clients, 1)
+	}
+}
+
+func TestHub_Resume(t *testing.T) {
+	previousHistory := config.ResumeHistory
+	config.ResumeHistory = 3
+	t.Cleanup(func() { config.ResumeHistory = previousHistory })
+	server, _ := newTestServer(t)
+	path := uniqueRoom("resume")
+	alice, _ := dial(t, server, path)
+	bob, _ := dial(t, server, path)
+	readUntil(t, bob, commons.DocSyncMessage)
+	seen := readUntil(t, alice, commons.DocSyncMessage).Sequence
+
+	send := func(value string) uint64 {
+		t.Helper()
+		message := insertMessage(1, value)
+		if err := bob.WriteJSON(&message); err != nil {
+			t.Fatalf("write error: %v\n", err)
+		}
+		return readUntil(t, bob, commons.AckMessage).Sequence
+	}
+	seen = max(seen, send("a"))
+	alice.Close()
+	missed := []uint64{send("b"), send("c")}
+
+	// Alice reconnects, and only gets what she missed.
+	alice, _ = dial(t, server, path+"?resume="+strconv.FormatUint(seen, 10))
+	for _, sequence := range missed {
+		if got := readMessage(t, alice); got.MessageType != "operation" || got.Sequence != sequence {
+			t.Errorf("expected operation %d; got %+v\n", sequence, got)
+		}
+	}
+
+	// Changes older than the history are no longer available, so the room state is sent instead.
+	for _, value := range []string{"d", "e", "f"} {
+		send(value)
+	}
+	carol, _ := dial(t, server, path+"?resume="+strconv.FormatUint(seen, 10))
+	if got := readMessage(t, carol); got.MessageType != commons.DocSyncMessage || crdt.Content(got.Document) != "fedcba" {
+		t.Errorf("expected the room state; got %+v\n", got)
+	}
+}
+
+func TestHub_StableSiteID(t *testing.T) {
+	server, _ := newTestServer(t)
+	path := uniqueRoom("session")
+	dialSession := func(secret string) (*websocket.Conn, commons.Message) {
+		t.Helper()
+		header := http.Header{commons.SessionHeader: []string{secret}}
+		connection, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+path, header)
+		if err != nil {
+			t.Fatalf("dial error: %v\n", err)
+		}
+		t.Cleanup(func() { connection.Close() })
+		return connection, readUntil(t, connection, commons.SiteIDMessage)
+	}
+
+	alice, site := dialSession("alice's secret")
+	readUntil(t, alice, commons.DocSyncMessage)
+	character := crdt.Character{ID: site.Text + ".7", Visible: true, Value: "a", PrevID: "start", NextID: "end"}
+	message := commons.Message{MessageType: "operation", Operation: commons.Operation{OperationType: "insert", Character: &character}}
+	if err := alice.WriteJSON(&message); err != nil {
+		t.Fatalf("write error: %v\n", err)
+	}
+	readUntil(t, alice, commons.AckMessage)
+	alice.Close()
+
+	// Alice reconnects with the same secret, and continues her site where she left it.
+	_, resumed := dialSession("alice's secret")
+	if resumed.Text != site.Text || resumed.Clock != 7 {
+		t.Errorf("site mismatch; got = %v.%v, expected = %v.%v\n", resumed.Text, resumed.Clock, site.Text, 7)
+	}
+	_, other := dialSession("bob's secret")
+	if other.Text == site.Text || other.Clock != 0 {
+		t.Errorf("expected a new site for another session; got = %v.%v\n", other.Text, other.Clock)
+	}
+}
+
+func TestHub_Shutdown(t *testing.T) {
+	previousDirectory := config.DataDir
+	config.DataDir = t.TempDir()
+	t.Cleanup(func() { config.DataDir = previousDirectory })
+	server, hub := newTestServer(t)
+	path := uniqueRoom("shutdown")
+	alice, _ := dial(t, server, path)
+	bob, _ := dial(t, server, path)
+	readUntil(t, alice, commons.DocSyncMessage)
+	readUntil(t, bob, commons.DocSyncMessage)
+	message := insertMessage(1, "x")
+	if err := alice.WriteJSON(&message); err != nil {
+		t.Fatalf("write error: %v\n", err)
+	}
+	readUntil(t, alice, commons.AckMessage)
+
+	select {
+	case <-hub.shutdown():
+	case <-time.After(2 * time.Second):
+		t.Fatalf("clients were not disconnected\n")
+	}
+	// Bob is still sent the change, and then told why he is disconnected.
+	readUntil(t, bob, "operation")
+	var closeErr *websocket.CloseError
+	if _, _, err := bob.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseServiceRestart || closeErr.Text != shutdownReason {
+		t.Errorf("close mismatch; got = %v, expected = %v %q\n", err, websocket.CloseServiceRestart, shutdownReason)
+	}
+	if got := restoredContent(t, filepath.Join(config.DataDir, strings.TrimPrefix(path, "/pad/"))); got != "x" {
+		t.Errorf("persisted content mismatch; got = %v, expected = %v\n", got, "x")
+	}
+	// Clients connecting afterwards are turned away.
+	carol, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+path, nil)
+	if err != nil {
+		t.Fatalf("dial error: %v\n", err)
+	}
+	defer carol.Close()
+	if _, _, err := carol.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseServiceRestart) {
+		t.Errorf("expected a client connecting after shutdown to be turned away; got %v\n", err)
+	}
+}
+
+func TestHub_RateLimit(t *testing.T) {
+	previousLimit, previousBurst, previousViolations := config.RateLimit, config.RateBurst, config.MaxViolations
+	config.RateLimit, config.RateBurst, config.MaxViolations = 1, 1, 1
+	t.Cleanup(func() {
+		config.RateLimit, config.RateBurst, config.MaxViolations = previousLimit, previousBurst, previousViolations
+	})
+	server, _ := newTestServer(t)
+	flooder, _ := dial(t, server, uniqueRoom("flood"))
+	for i := 0; i < 3; i++ {
+		if err := flooder.WriteJSON(&commons.Message{MessageType: commons.JoinMessage, Username: "flooder"}); err != nil {
+			t.Fatalf("write error: %v\n", err)
+		}
+	}
+	if message := readUntil(t, flooder, commons.ErrorMessage); message.Error == nil || message.Error.Code != commons.ErrorRateLimited {
+		t.Errorf("expected a rate limiting error; got %+v\n", message)
+	}
+	expectClose(t, flooder, websocket.ClosePolicyViolation)
+}
+
+func TestHub_MessageTooLarge(t *testing.T) {
+	previous := config.MaxMessageSize
+	config.MaxMessageSize = 1024
+	t.Cleanup(func() { config.MaxMessageSize = previous })
+	server, _ := newTestServer(t)
+	client, _ := dial(t, server, uniqueRoom("large"))
+	readUntil(t, client, commons.DocSyncMessage)
+	if err := client.WriteJSON(&commons.Message{MessageType: commons.JoinMessage, Username: strings.Repeat("x", 2048)}); err != nil {
+		t.Fatalf("write error: %v\n", err)
+	}
+	// The client is told why before the connection is closed.
+	if message := readMessage(t, client); message.MessageType != commons.ErrorMessage || message.Error == nil || message.Error.Code != commons.ErrorMessageTooLarge {
+		t.Errorf("expected a message too large error; got %+v\n", message)
+	}
+	expectClose(t, client, websocket.CloseMessageTooBig)
+}
+
+func TestHub_RejectsInvalidMessages(t *testing.T) {
+	server, _ := newTestServer(t)
+	client, _ := dial(t, server, uniqueRoom("invalid"))
+	readUntil(t, client, commons.DocSyncMessage)
+	if err := client.WriteMessage(websocket.TextMessage, []byte("{not json")); err != nil {
+		t.Fatalf("write error: %v\n", err)
+	}
+	if message := readUntil(t, client, commons.ErrorMessage); message.Error == nil || message.Error.Code != commons.ErrorMalformedMessage {
+		t.Errorf("expected a malformed message error; got %+v\n", message)
+	}
+	invalid := insertMessage(-1, "x")
+	if err := client.WriteJSON(&invalid); err != nil {
+		t.Fatalf("write error: %v\n", err)
+	}
+	if message := readUntil(t, client, commons.ErrorMessage); message.Error == nil || message.Error.Code != commons.ErrorInvalidOperation {
+		t.Errorf("expected an invalid operation error; got %+v\n", message)
+	}
+	// The client stays connected.
+	valid := insertMessage(1, "x")
+	if err := client.WriteJSON(&valid); err != nil {
+		t.Fatalf("write error: %v\n", err)
+	}
+	readUntil(t, client, commons.AckMessage)
+}
+
+func TestHub_StateRequest(t *testing.T) {
+	server, _ := newTestServer(t)
+	path := uniqueRoom("state")
+	alice, _ := dial(t, server, path)
+	readUntil(t, alice, commons.DocSyncMessage)
+	bob, _ := dial(t, server, path)
+	readUntil(t, bob, commons.DocSyncMessage)
+
+	insert := insertMessage(1, "x")
+	if err := alice.WriteJSON(&insert); err != nil {
+		t.Fatalf("write error: %v\n", err)
+	}
+	readUntil(t, alice, commons.AckMessage)
+	if err := bob.WriteJSON(&commons.Message{MessageType: commons.StateReqMessage}); err != nil {
+		t.Fatalf("write error: %v\n", err)
+	}
+	if state := readUntil(t, bob, commons.DocSyncMessage); crdt.Content(state.Document) != "x" || state.Sequence != 1 {
+		t.Errorf("state mismatch; got = %q at %d, expected = %q at %d\n", crdt.Content(state.Document), state.Sequence, "x", 1)
+	}
+
+	// The state is only sent to the client that asked for it.
+	if err := alice.WriteJSON(&insert); err != nil {
+		t.Fatalf("write error: %v\n", err)
+	}
+	if message := readMessage(t, alice); message.MessageType != commons.AckMessage {
+		t.Errorf("message type mismatch; got = %v, expected = %v\n", message.MessageType, commons.AckMessage)
+	}
+}
+
+func TestHub_RejectsUnappliedChanges(t *testing.T) {
+	server, _ := newTestServer(t)
+	path := uniqueRoom("unapplied")
+	alice, aliceSite := dial(t, server, path)
+	readUntil(t, alice, commons.DocSyncMessage)
+	bob, _ := dial(t, server, path)
+	readUntil(t, bob, commons.DocSyncMessage)
+
+	orphan := crdt.Character{ID: aliceSite.Text + ".1", Visible: true, Value: "x", PrevID: "9.9", NextID: crdt.EndCharacter.ID}
+	if err := alice.WriteJSON(&commons.Message{MessageType: "operation", Operation: commons.Operation{OperationType: "insert", Value: "x", Character: &orphan}}); err != nil {
+		t.Fatalf("write error: %v\n", err)
+	}
+	if message := readMessage(t, alice); message.MessageType != commons.ErrorMessage || message.Error == nil || message.Error.Code != commons.ErrorInvalidOperation {
+		t.Errorf("expected an invalid operation error instead of an ack; got %+v\n", message)
+	}
+
+	// Bob only gets the next, valid, change.
+	valid := insertMessage(1, "y")
+	if err := alice.WriteJSON(&valid); err != nil {
+		t.Fatalf("write error: %v\n", err)
+	}
+	readUntil(t, alice, commons.AckMessage)
+	if message := readUntil(t, bob, "operation"); message.Operation.Value != "y" || message.Sequence != 1 {
+		t.Errorf("forwarded operation mismatch; got = %q at %d, expected = %q at %d\n", message.Operation.Value, message.Sequence, "y", 1)
+	}
+}
+
+func TestHub_AnswersChangeIDs(t *testing.T) {
+	server, _ := newTestServer(t)
+	path := uniqueRoom("changes")
+	alice, _ := dial(t, server, path)
+	readUntil(t, alice, commons.DocSyncMessage)
+	bob, _ := dial(t, server, path)
+	readUntil(t, bob, commons.DocSyncMessage)
+
+	changes := []struct {
+		message  commons.Message
+		expected commons.MessageType
+	}{
+		{insertMessage(1, "x"), commons.AckMessage},
+		{insertMessage(-1, "x"), commons.ErrorMessage},
+		{insertMessage(2, "y"), commons.AckMessage},
+	}
+	for i, change := range changes {
+		change.message.ChangeID = uint64(i + 1)
+		if err := alice.WriteJSON(&change.message); err != nil {
+			t.Fatalf("write error: %v\n", err)
+		}
+		answer := readUntil(t, alice, change.expected)
+		if answer.ChangeID != uint64(i+1) {
+			t.Errorf("change ID mismatch; got = %v, expected = %v\n", answer.ChangeID, i+1)
+		}
+	}
+
+	// The ID is only meant for the sender.
+	if message := readUntil(t, bob, "operation"); message.ChangeID != 0 {
+		t.Errorf("forwarded change ID mismatch; got = %v, expected = %v\n", message.ChangeID, 0)
+	}
+}
+
+func TestHub_ForwardedDocSyncIsUnchanged(t *testing.T) {
+	server, _ := newTestServer(t)
+	path := uniqueRoom("forwarded")
+	alice, aliceSite := dial(t, server, path)
+	readUntil(t, alice, commons.DocSyncMessage)
+	bob, _ := dial(t, server, path)
+	readUntil(t, bob, commons.DocSyncMessage)
+
+	document := crdt.NewSyncedDocument(crdt.New())
+	for i := 1; i <= 50; i++ {
+		if _, err := document.InsertWithID(i, "x", fmt.Sprintf("%s.%d", aliceSite.Text, i), "alice"); err != nil {
+			t.Fatalf("error: %v\n", err)
+		}
+	}
+	if err := alice.WriteJSON(&commons.Message{MessageType: commons.DocSyncMessage, Document: document.Snapshot()}); err != nil {
+		t.Fatalf("write error: %v\n", err)
+	}
+	// The replica's later changes don't reach the docSync forwarded to bob.
+	character := crdt.Character{ID: aliceSite.Text + ".1"}
+	if err := alice.WriteJSON(&commons.Message{MessageType: "operation", Operation: commons.Operation{OperationType: "delete", Position: 1, Character: &character}}); err != nil {
+		t.Fatalf("write error: %v\n", err)
+	}
+	if got := crdt.Content(readUntil(t, bob, commons.DocSyncMessage).Document); len(got) != 50 {
+		t.Errorf("forwarded document length mismatch; got = %v, expected = %v\n", len(got), 50)
+	}
+}
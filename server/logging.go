@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/omesh-barhate/coderpad/commons"
+)
+
+// The server logs with log/slog, as text or JSON lines (-log-format). Log records about a connection carry its request ID,
+// room and client ID, so every line about a client can be found. Message payloads (operations' characters, documents,
+// presences, metadata values) are user content, so loggers drop them unless -log-payloads is set.
+
+// payloadKey is the key of a message's content in logs.
+const payloadKey = "payload"
+
+// newLogger returns a logger writing to writer in the given format ("text" or "json"), from the given level.
+// Messages' content is only logged if payloads is set.
+func newLogger(writer io.Writer, format, level string, payloads bool) (*slog.Logger, error) {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	options := &slog.HandlerOptions{Level: logLevel}
+	if !payloads {
+		options.ReplaceAttr = func(groups []string, attr slog.Attr) slog.Attr {
+			if attr.Key == payloadKey && len(groups) > 0 {
+				return slog.Attr{}
+			}
+			return attr
+		}
+	}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(writer, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(writer, options)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q", format)
+}
+
+// fatal logs an error and exits.
+func fatal(message string, args ...any) {
+	slog.Error(message, args...)
+	os.Exit(1)
+}
+
+// messageAttr describes a message for logs. Its content is dropped by loggers that don't log payloads.
+func messageAttr(message commons.Message) slog.Attr {
+	attrs := []any{slog.String("type", string(message.MessageType))}
+	if message.Sequence != 0 {
+		attrs = append(attrs, slog.Uint64("seq", message.Sequence))
+	}
+	if message.MessageType == "operation" {
+		attrs = append(attrs, slog.String("operation", message.Operation.OperationType))
+	}
+	if message.MessageType == commons.DocSyncMessage {
+		attrs = append(attrs, slog.Int("characters", len(message.Document.Characters)))
+	}
+	attrs = append(attrs, slog.Any(payloadKey, message))
+	return slog.Group("message", attrs...)
+}
+
+type requestLoggerKey struct{}
+
+// requestIDPattern is what a request ID received in X-Request-ID must look like to be kept.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestLogger returns the logger of a request handled by withRequestLogging.
+func requestLogger(request *http.Request) *slog.Logger {
+	if logger, ok := request.Context().Value(requestLoggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// withRequestLogging gives every request an ID (the X-Request-ID header, if it has a valid one) and a logger carrying it,
+// and logs the request once it is handled. The query isn't logged, as it may hold an access token.
+func withRequestLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		requestID := request.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = uuid.NewString()
+		}
+		response.Header().Set("X-Request-ID", requestID)
+		logger := slog.With(slog.String("request", requestID))
+		recorder := &statusRecorder{ResponseWriter: response, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recorder, request.WithContext(context.WithValue(request.Context(), requestLoggerKey{}, logger)))
+		logger.Info("request handled",
+			slog.String("method", request.Method), slog.String("path", request.URL.Path), slog.String("remote", remoteHost(request)),
+			slog.Int("status", recorder.status), slog.Duration("duration", time.Since(start)))
+	})
+}
+
+func remoteHost(request *http.Request) string {
+	if host, _, err := net.SplitHostPort(request.RemoteAddr); err == nil {
+		return host
+	}
+	return strings.TrimSpace(request.RemoteAddr)
+}
+
+// statusRecorder records the status of a response. It can be hijacked, for WebSocket upgrades.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	recorder.status = http.StatusSwitchingProtocols
+	return http.NewResponseController(recorder.ResponseWriter).Hijack()
+}
+
+func (recorder *statusRecorder) Unwrap() http.ResponseWriter {
+	return recorder.ResponseWriter
+}
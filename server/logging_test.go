@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/gorilla/websocket"
+	"github.com/omesh-barhate/coderpad/commons"
+)
+
+// syncBuffer is a buffer logs can be written to from several goroutines.
+type syncBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (buffer *syncBuffer) Write(data []byte) (int, error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return buffer.buffer.Write(data)
+}
+
+func (buffer *syncBuffer) String() string {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return buffer.buffer.String()
+}
+
+// records decodes the JSON log records written so far.
+func (buffer *syncBuffer) records(t *testing.T) []map[string]any {
+	t.Helper()
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buffer.buffer.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("decode error: %v in %q\n", err, line)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// captureLogs makes the default logger write JSON records to the returned buffer until the end of the test.
+func captureLogs(t *testing.T, level string) *syncBuffer {
+	t.Helper()
+	buffer := &syncBuffer{}
+	logger, err := newLogger(buffer, "json", level, false)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return buffer
+}
+
+// waitForRecord waits for a log record with the given message, and returns it.
+func waitForRecord(t *testing.T, buffer *syncBuffer, message string) map[string]any {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		for _, record := range buffer.records(t) {
+			if record["msg"] == message {
+				return record
+			}
+		}
+	}
+	t.Fatalf("no %q record logged\n", message)
+	return nil
+}
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		format, level string
+		valid         bool
+	}{
+		{"text", "info", true},
+		{"json", "debug", true},
+		{"json", "WARN", true},
+		{"xml", "info", false},
+		{"text", "verbose", false},
+	}
+	for _, test := range tests {
+		_, err := newLogger(&bytes.Buffer{}, test.format, test.level, false)
+		if got := err == nil; got != test.valid {
+			t.Errorf("validity mismatch for %s/%s; got = %v, expected = %v\n", test.format, test.level, got, test.valid)
+		}
+	}
+
+	var buffer bytes.Buffer
+	logger, _ := newLogger(&buffer, "json", "warn", false)
+	logger.Info("hidden")
+	logger.Warn("shown", slog.String("room", "pad"))
+	var record map[string]any
+	if err := json.Unmarshal(buffer.Bytes(), &record); err != nil {
+		t.Fatalf("decode error: %v in %q\n", err, buffer.String())
+	}
+	if record["msg"] != "shown" || record["room"] != "pad" {
+		t.Errorf("record mismatch; got = %v, expected = %v\n", record, "a warning about room pad")
+	}
+}
+
+func TestMessageAttr_RedactsPayloads(t *testing.T) {
+	message := insertMessage(1, "secret")
+	message.Sequence = 7
+	log := func(payloads bool) string {
+		var buffer bytes.Buffer
+		logger, _ := newLogger(&buffer, "json", "info", payloads)
+		logger.Info("message", messageAttr(message))
+		return buffer.String()
+	}
+
+	redacted := log(false)
+	if strings.Contains(redacted, "secret") {
+		t.Errorf("payload logged without -log-payloads: %s\n", redacted)
+	}
+	for _, field := range []string{`"type":"operation"`, `"seq":7`, `"operation":"insert"`} {
+		if !strings.Contains(redacted, field) {
+			t.Errorf("field missing; got = %s, expected = %s\n", redacted, field)
+		}
+	}
+
+	if full := log(true); !strings.Contains(full, "secret") {
+		t.Errorf("payload missing with -log-payloads: %s\n", full)
+	}
+}
+
+func TestWithRequestLogging(t *testing.T) {
+	logs := captureLogs(t, "info")
+	handler := withRequestLogging(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		requestLogger(request).Info("handling")
+		http.Error(response, "teapot", http.StatusTeapot)
+	}))
+
+	tests := []struct {
+		header string
+		kept   bool
+	}{
+		{"abc-123", true},
+		{"", false},
+		{"not valid!", false},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, "/rooms/pad/content?token=hidden", nil)
+		if test.header != "" {
+			request.Header.Set("X-Request-ID", test.header)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		requestID := recorder.Header().Get("X-Request-ID")
+		if got := requestID == test.header; got != test.kept {
+			t.Errorf("request ID mismatch for %q; got = %q, expected kept = %v\n", test.header, requestID, test.kept)
+		}
+		if !requestIDPattern.MatchString(requestID) {
+			t.Errorf("invalid request ID; got = %q\n", requestID)
+		}
+	}
+
+	records := logs.records(t)
+	if len(records) != 2*len(tests) {
+		t.Fatalf("record count mismatch; got = %v, expected = %v\n", len(records), 2*len(tests))
+	}
+	handling, handled := records[0], records[1]
+	if handling["request"] != "abc-123" {
+		t.Errorf("handler's record mismatch; got = %v, expected request = %v\n", handling, "abc-123")
+	}
+	expected := map[string]any{"msg": "request handled", "request": "abc-123", "method": "GET", "path": "/rooms/pad/content", "status": float64(http.StatusTeapot)}
+	for key, value := range expected {
+		if diff := cmp.Diff(value, handled[key]); diff != "" {
+			t.Errorf("%s mismatch (-expected +got):\n%s", key, diff)
+		}
+	}
+	if strings.Contains(logs.String(), "hidden") {
+		t.Errorf("query logged: %s\n", logs.String())
+	}
+}
+
+func TestClientLogs(t *testing.T) {
+	logs := captureLogs(t, "debug")
+	server, _ := newTestServer(t)
+	path := uniqueRoom("logs")
+
+	header := http.Header{"X-Request-ID": {"connection-1"}}
+	connection, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+path, header)
+	if err != nil {
+		t.Fatalf("dial error: %v\n", err)
+	}
+	siteID := readUntil(t, connection, commons.SiteIDMessage)
+	if err := connection.WriteJSON(commons.Message{MessageType: commons.JoinMessage, Username: "alice"}); err != nil {
+		t.Fatalf("write error: %v\n", err)
+	}
+	if err := connection.WriteJSON(insertMessage(1, "ß")); err != nil {
+		t.Fatalf("write error: %v\n", err)
+	}
+
+	joined := waitForRecord(t, logs, "client joined")
+	expected := map[string]any{"request": "connection-1", "room": strings.TrimPrefix(path, "/pad/"), "client": siteID.ClientID.String(), "username": "alice"}
+	for key, value := range expected {
+		if diff := cmp.Diff(value, joined[key]); diff != "" {
+			t.Errorf("%s mismatch (-expected +got):\n%s", key, diff)
+		}
+	}
+
+	connection.Close()
+	handled := waitForRecord(t, logs, "request handled")
+	if diff := cmp.Diff(float64(http.StatusSwitchingProtocols), handled["status"]); diff != "" {
+		t.Errorf("status mismatch (-expected +got):\n%s", diff)
+	}
+	waitForRecord(t, logs, "client disconnected")
+	if strings.Contains(logs.String(), "ß") {
+		t.Errorf("payload logged without -log-payloads: %s\n", logs.String())
+	}
+}
@@ -1,150 +1,196 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
-	"sync"
+	"syscall"
 	"time"
 
-	"github.com/fatih/color"
-	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/omesh-barhate/coderpad/commons"
 )
 
-type ClientInfo struct {
-	Username string `json:"username"`
-	SiteID   string `json:"siteID"`
-	Conn     *websocket.Conn
-}
-
 var (
-	nextSiteID     = 0
-	siteIDMutex    sync.Mutex
-	wsUpgrader     = websocket.Upgrader{}
-	activeClients  = make(map[uuid.UUID]ClientInfo)
-	messageChannel = make(chan commons.Message)
-	syncChannel    = make(chan commons.Message)
+	// config is the server's configuration, loaded once at startup.
+	config     = defaultConfig()
+	wsUpgrader = websocket.Upgrader{}
 )
 
 func main() {
-	address := flag.String("addr", ":8080", "Server address")
-	flag.Parse()
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		if err := runTokenCommand(os.Args[2:]); err != nil {
+			fatal("error creating token", slog.Any("error", err))
+		}
+		return
+	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", handleWebSocket)
+	loaded, printOnly, err := loadConfig(flag.CommandLine, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		fatal("error loading the configuration, exiting", slog.Any("error", err))
+	}
+	if printOnly {
+		if err := loaded.print(os.Stdout); err != nil {
+			fatal("error printing the configuration", slog.Any("error", err))
+		}
+	}
+	if err := loaded.validate(); err != nil {
+		fatal("invalid configuration, exiting", slog.Any("error", err))
+	}
+	if printOnly {
+		return
+	}
+	config = loaded
+	logger, _ := newLogger(os.Stderr, config.LogFormat, config.LogLevel, config.LogPayloads)
+	slog.SetDefault(logger)
 
-	go syncHandler()
-	go messageHandler()
+	hub := newHub()
+	if err := hub.restoreRooms(); err != nil {
+		fatal("error restoring rooms, exiting", slog.Any("error", err))
+	}
+	go hub.run()
 
-	log.Printf("Starting server on %s", *address)
+	mux := newServeMux(hub)
 
 	server := &http.Server{
-		Addr:         *address,
+		Addr:         config.Addr,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
-		Handler:      mux,
+		Handler:      withRequestLogging(mux),
 	}
 
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatal("Error starting server, exiting.", err)
+	stop, _ := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	served := make(chan error, 2)
+	var adminServer *http.Server
+	if config.AdminAddr != "" {
+		adminServer = &http.Server{
+			Addr:         config.AdminAddr,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			Handler:      withRequestLogging(newAdminMux(hub, config.AdminToken)),
+		}
+		slog.Info("starting admin API", slog.String("addr", config.AdminAddr))
+		go func() { served <- adminServer.ListenAndServe() }()
 	}
-}
-
-func handleWebSocket(response http.ResponseWriter, request *http.Request) {
-	clientConnection, err := wsUpgrader.Upgrade(response, request, nil)
-	if err != nil {
-		color.Red("WebSocket upgrade error: %v\n", err)
+	if config.Cert == "" {
+		slog.Info("starting server", slog.String("addr", config.Addr))
+		go func() { served <- server.ListenAndServe() }()
+	} else {
+		tlsConfig, err := newTLSConfig(config.Cert, config.Key, config.ClientCA)
+		if err != nil {
+			fatal("error loading TLS configuration, exiting", slog.Any("error", err))
+		}
+		server.TLSConfig = tlsConfig
+		slog.Info("starting TLS server", slog.String("addr", config.Addr))
+		go func() { served <- server.ListenAndServeTLS("", "") }()
+	}
+	select {
+	case err := <-served:
+		fatal("error starting server, exiting", slog.Any("error", err))
+	case <-stop.Done():
 	}
-	defer clientConnection.Close()
-
-	color.Yellow("active clients: %d\n", len(activeClients))
-
-	clientID := uuid.New()
 
-	siteIDMutex.Lock()
-	nextSiteID++
-	siteIDMutex.Unlock()
+	slog.Info("shutting down", slog.Duration("timeout", config.ShutdownTimeout))
+	deadline, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if adminServer != nil {
+		_ = adminServer.Shutdown(deadline)
+	}
+	if err := shutdown(deadline, server, hub); err != nil {
+		fatal("error shutting down, exiting", slog.Any("error", err))
+	}
+	slog.Info("shut down")
+}
 
-	siteIDString := strconv.Itoa(nextSiteID)
+// shutdown stops accepting connections, closes every client's connection once its pending messages are sent,
+// and persists every room, unless the deadline passes first.
+func shutdown(deadline context.Context, server *http.Server, hub *Hub) error {
+	// WebSocket connections are hijacked, so Shutdown only waits for requests still being upgraded.
+	err := server.Shutdown(deadline)
+	select {
+	case <-hub.shutdown():
+		return err
+	case <-deadline.Done():
+		return errors.Join(err, fmt.Errorf("clients were still being sent their pending messages: %w", deadline.Err()))
+	}
+}
 
-	clientInfo := ClientInfo{Conn: clientConnection, SiteID: siteIDString}
-	activeClients[clientID] = clientInfo
+// sessionKey returns the hash of a session secret, which is what the server stores.
+func sessionKey(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
 
-	color.Magenta("clients after SiteID: %+v", activeClients)
-	color.Yellow("Assigned siteID: %s", clientInfo.SiteID)
+func newServeMux(hub *Hub) *http.ServeMux {
+	mux := http.NewServeMux()
+	handler := func(response http.ResponseWriter, request *http.Request) {
+		handleWebSocket(hub, response, request)
+	}
+	mux.HandleFunc("/", handler)
+	mux.HandleFunc("GET /metrics", func(response http.ResponseWriter, request *http.Request) {
+		handleMetrics(hub, response, request)
+	})
+	mux.HandleFunc("/pad/{room}", handler)
+	mux.HandleFunc("GET /rooms/{room}/content", func(response http.ResponseWriter, request *http.Request) {
+		handleGetContent(hub, response, request)
+	})
+	mux.HandleFunc("PUT /rooms/{room}/content", func(response http.ResponseWriter, request *http.Request) {
+		handlePutContent(hub, response, request)
+	})
+	mux.HandleFunc("GET /rooms/{room}/snapshot", func(response http.ResponseWriter, request *http.Request) {
+		handleGetSnapshot(hub, response, request)
+	})
+	return mux
+}
 
-	siteIDMessage := commons.Message{MessageType: commons.SiteIDMessage, Text: clientInfo.SiteID, ClientID: clientID}
-	if err := clientConnection.WriteJSON(siteIDMessage); err != nil {
-		color.Red("Failed to send siteID message")
+func handleWebSocket(hub *Hub, response http.ResponseWriter, request *http.Request) {
+	roomID := request.PathValue("room")
+	if request.URL.Path == "/" {
+		roomID = defaultRoomID
+	}
+	if !roomIDPattern.MatchString(roomID) {
+		http.Error(response, "invalid room", http.StatusNotFound)
+		return
 	}
 
-	for id, info := range activeClients {
-		if id != clientID {
-			message := commons.Message{MessageType: commons.DocReqMessage, ClientID: clientID}
-			color.Cyan("sending docReq to %s for %s", id, clientID)
-			if err := info.Conn.WriteJSON(&message); err != nil {
-				color.Red("Failed to send docReq: %v\n", err)
-				continue
-			}
-			break
-		}
+	claims, err := authorize(request, roomID)
+	if err != nil {
+		requestLogger(request).Warn("rejected connection", slog.String("room", roomID), slog.Any("error", err))
+		http.Error(response, err.Error(), http.StatusUnauthorized)
+		return
 	}
 
-	for {
-		var message commons.Message
-		if err := clientConnection.ReadJSON(&message); err != nil {
-			color.Red("Closing connection for username: %v\n", activeClients[clientID].Username)
-			delete(activeClients, clientID)
-			break
-		}
-		message.ClientID = clientID
-		if message.MessageType == commons.DocSyncMessage {
-			syncChannel <- message
-			continue
-		}
-		messageChannel <- message
+	room, err := hub.room(roomID)
+	if err != nil {
+		requestLogger(request).Error("room unavailable", slog.String("room", roomID), slog.Any("error", err))
+		http.Error(response, "room unavailable", http.StatusInternalServerError)
+		return
 	}
-}
 
-func messageHandler() {
-	for {
-		message := <-messageChannel
-		timestamp := time.Now().Format(time.ANSIC)
-		if message.MessageType == commons.JoinMessage {
-			info := activeClients[message.ClientID]
-			info.Username = message.Username
-			activeClients[message.ClientID] = info
-			color.Green("%s >> %s %s (ID: %s)\n", timestamp, message.Username, message.Text, message.ClientID)
-		} else if message.MessageType == "operation" {
-			color.Green("operation >> %+v from ID=%s\n", message.Operation, message.ClientID)
-		} else {
-			color.Green("%s >> %+v\n", timestamp, message)
-		}
-		for id, info := range activeClients {
-			if id != message.ClientID {
-				color.Magenta("writing message to: %s, msg: %+v\n", id, message)
-				if err := info.Conn.WriteJSON(message); err != nil {
-					color.Red("Send error: %v\n", err)
-					info.Conn.Close()
-					delete(activeClients, id)
-				}
-			}
-		}
+	clientConnection, err := wsUpgrader.Upgrade(response, request, nil)
+	if err != nil {
+		requestLogger(request).Warn("WebSocket upgrade failed", slog.Any("error", err))
+		return
 	}
-}
 
-func syncHandler() {
-	for {
-		syncMessage := <-syncChannel
-		color.Cyan("got syncMsg, len(document) = %d\n", len(syncMessage.Document.Characters))
-		for id, info := range activeClients {
-			if id != syncMessage.ClientID {
-				color.Cyan("sending syncMsg to %s", syncMessage.ClientID)
-				_ = info.Conn.WriteJSON(syncMessage)
-			}
-		}
+	client := newClient(room, clientConnection, requestRole(request, claims), requestLogger(request))
+	client.session = sessionKey(request.Header.Get(commons.SessionHeader))
+	if resume := request.URL.Query().Get("resume"); resume != "" {
+		client.resumeFrom, err = strconv.ParseUint(resume, 10, 64)
+		client.resuming = err == nil
 	}
+	hub.register <- client
+	go client.writePump(hub)
+	client.readPump(hub)
 }
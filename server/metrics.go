@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/omesh-barhate/coderpad/commons"
+)
+
+// hubStats counts what happened to the hub's clients. It is owned by the hub goroutine.
+type hubStats struct {
+	// Disconnects counts disconnected clients by reason.
+	Disconnects map[string]int
+	// Resyncs counts clients whose queued messages were replaced by the room state.
+	Resyncs int
+	// DroppedMessages counts messages dropped by resyncs.
+	DroppedMessages int
+	// Messages counts messages received from clients by type, and Operations counts operations by type.
+	Messages   map[string]int
+	Operations map[string]int
+
+	// The fields below are only filled in by snapshot.
+
+	Clients int
+	Rooms   int
+	// QueueDepth and MaxQueueDepth are the total and largest number of messages waiting in clients' send queues.
+	QueueDepth    int
+	MaxQueueDepth int
+	// BytesIn and BytesOut count the bytes of the messages received from and sent to clients.
+	BytesIn, BytesOut uint64
+	// BroadcastLatency is how long messages waited in clients' send queues.
+	BroadcastLatency histogramSnapshot
+}
+
+// snapshot returns a copy of the hub's stats, with the current queue depths.
+func (hub *Hub) snapshot() hubStats {
+	var stats hubStats
+	hub.call(func() {
+		stats = hub.stats
+		stats.Disconnects = maps.Clone(hub.stats.Disconnects)
+		stats.Messages = maps.Clone(hub.stats.Messages)
+		stats.Operations = maps.Clone(hub.stats.Operations)
+		stats.Rooms = len(hub.rooms)
+		for _, room := range hub.rooms {
+			stats.Clients += len(room.clients)
+			for _, client := range room.clients {
+				depth := len(client.send)
+				stats.QueueDepth += depth
+				stats.MaxQueueDepth = max(stats.MaxQueueDepth, depth)
+			}
+		}
+	})
+	stats.BytesIn, stats.BytesOut = hub.bytesIn.Load(), hub.bytesOut.Load()
+	stats.BroadcastLatency = hub.broadcastLatency.snapshot()
+	return stats
+}
+
+// latencyBuckets are the upper bounds, in seconds, of the broadcast latency histogram's buckets.
+var latencyBuckets = [...]float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// histogram counts durations in latencyBuckets. It is safe for concurrent use.
+type histogram struct {
+	buckets [len(latencyBuckets)]atomic.Uint64
+	count   atomic.Uint64
+	// sum is in nanoseconds.
+	sum atomic.Int64
+}
+
+func (histogram *histogram) observe(duration time.Duration) {
+	// Durations above the last bucket are only counted in count, as Prometheus' +Inf bucket.
+	if bucket := sort.SearchFloat64s(latencyBuckets[:], duration.Seconds()); bucket < len(latencyBuckets) {
+		histogram.buckets[bucket].Add(1)
+	}
+	histogram.count.Add(1)
+	histogram.sum.Add(int64(duration))
+}
+
+// histogramSnapshot holds cumulative bucket counts, as Prometheus expects them.
+type histogramSnapshot struct {
+	Buckets [len(latencyBuckets)]uint64
+	Count   uint64
+	Sum     time.Duration
+}
+
+func (histogram *histogram) snapshot() histogramSnapshot {
+	var snapshot histogramSnapshot
+	var cumulative uint64
+	for i := range histogram.buckets {
+		cumulative += histogram.buckets[i].Load()
+		snapshot.Buckets[i] = cumulative
+	}
+	// Observations may land between the loads above, so the total can't be less than the buckets'.
+	snapshot.Count = max(histogram.count.Load(), cumulative)
+	snapshot.Sum = time.Duration(histogram.sum.Load())
+	return snapshot
+}
+
+// metricMessageTypes are the message types counted by name; any other is counted as "other".
+var metricMessageTypes = []commons.MessageType{
+	"operation", commons.DocSyncMessage, commons.JoinMessage, commons.DigestMessage,
+	commons.StateReqMessage, commons.MetadataMessage, commons.PresenceMessage,
+}
+
+var metricOperationTypes = []string{"insert", "delete"}
+
+func messageLabel(messageType commons.MessageType) string {
+	if slices.Contains(metricMessageTypes, messageType) {
+		return string(messageType)
+	}
+	return "other"
+}
+
+func operationLabel(operationType string) string {
+	if slices.Contains(metricOperationTypes, operationType) {
+		return operationType
+	}
+	return "other"
+}
+
+// handleMetrics serves the hub's stats in the Prometheus text format.
+func handleMetrics(hub *Hub, response http.ResponseWriter, _ *http.Request) {
+	response.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(response, hub.snapshot())
+}
+
+func writeMetrics(writer io.Writer, stats hubStats) {
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	labelled := func(name, label string, values map[string]int) {
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(writer, "%s{%s=%q} %d\n", name, label, key, values[key])
+		}
+	}
+
+	metric("coderpad_clients", "gauge", "Connected clients.")
+	fmt.Fprintf(writer, "coderpad_clients %d\n", stats.Clients)
+	metric("coderpad_rooms", "gauge", "Rooms in memory.")
+	fmt.Fprintf(writer, "coderpad_rooms %d\n", stats.Rooms)
+	metric("coderpad_messages_received_total", "counter", "Messages received from clients, by type.")
+	labelled("coderpad_messages_received_total", "type", stats.Messages)
+	metric("coderpad_operations_total", "counter", "Operations received from clients, by type.")
+	labelled("coderpad_operations_total", "type", stats.Operations)
+	metric("coderpad_received_bytes_total", "counter", "Bytes of the messages received from clients.")
+	fmt.Fprintf(writer, "coderpad_received_bytes_total %d\n", stats.BytesIn)
+	metric("coderpad_sent_bytes_total", "counter", "Bytes of the messages sent to clients.")
+	fmt.Fprintf(writer, "coderpad_sent_bytes_total %d\n", stats.BytesOut)
+
+	metric("coderpad_broadcast_latency_seconds", "histogram", "How long messages waited in a client's send queue before being written.")
+	for i, bound := range latencyBuckets {
+		fmt.Fprintf(writer, "coderpad_broadcast_latency_seconds_bucket{le=\"%g\"} %d\n", bound, stats.BroadcastLatency.Buckets[i])
+	}
+	fmt.Fprintf(writer, "coderpad_broadcast_latency_seconds_bucket{le=\"+Inf\"} %d\n", stats.BroadcastLatency.Count)
+	fmt.Fprintf(writer, "coderpad_broadcast_latency_seconds_sum %g\n", stats.BroadcastLatency.Sum.Seconds())
+	fmt.Fprintf(writer, "coderpad_broadcast_latency_seconds_count %d\n", stats.BroadcastLatency.Count)
+
+	metric("coderpad_send_queue_depth", "gauge", "Messages waiting in all clients' send queues.")
+	fmt.Fprintf(writer, "coderpad_send_queue_depth %d\n", stats.QueueDepth)
+	metric("coderpad_send_queue_max_depth", "gauge", "Messages waiting in the fullest client send queue.")
+	fmt.Fprintf(writer, "coderpad_send_queue_max_depth %d\n", stats.MaxQueueDepth)
+	metric("coderpad_disconnects_total", "counter", "Disconnected clients, by reason.")
+	labelled("coderpad_disconnects_total", "reason", stats.Disconnects)
+	metric("coderpad_resyncs_total", "counter", "Clients whose queued messages were replaced by the room state.")
+	fmt.Fprintf(writer, "coderpad_resyncs_total %d\n", stats.Resyncs)
+	metric("coderpad_dropped_messages_total", "counter", "Queued messages dropped by resyncs.")
+	fmt.Fprintf(writer, "coderpad_dropped_messages_total %d\n", stats.DroppedMessages)
+}
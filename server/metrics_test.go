@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/omesh-barhate/coderpad/commons"
+)
+
+func TestHistogram(t *testing.T) {
+	var histogram histogram
+	for _, duration := range []time.Duration{200 * time.Microsecond, 3 * time.Millisecond, 3 * time.Millisecond, 10 * time.Second} {
+		histogram.observe(duration)
+	}
+	snapshot := histogram.snapshot()
+	// 0.0005, 0.001, 0.0025, 0.005, ...
+	if got, expected := snapshot.Buckets[:4], []uint64{1, 1, 1, 3}; !cmp.Equal(got, expected) {
+		t.Errorf("buckets mismatch; got = %v, expected = %v\n", got, expected)
+	}
+	if snapshot.Buckets[len(latencyBuckets)-1] != 3 || snapshot.Count != 4 {
+		t.Errorf("count mismatch; got = %+v\n", snapshot)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	server, _ := newTestServer(t)
+	path := uniqueRoom("metrics")
+	alice, _ := dial(t, server, path)
+	bob, _ := dial(t, server, path)
+	readUntil(t, bob, commons.DocSyncMessage)
+	for _, message := range []commons.Message{insertMessage(1, "x"), {MessageType: "bogus"}} {
+		if err := alice.WriteJSON(&message); err != nil {
+			t.Fatalf("write error: %v\n", err)
+		}
+	}
+	readUntil(t, bob, "operation")
+	// Invalid messages are rejected before they reach the hub, so they aren't counted.
+	readUntil(t, alice, commons.ErrorMessage)
+
+	response, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	metrics := string(body)
+	for _, expected := range []string{
+		"# TYPE coderpad_clients gauge\ncoderpad_clients 2\n",
+		"coderpad_rooms 1\n",
+		`coderpad_messages_received_total{type="operation"} 1` + "\n",
+		`coderpad_operations_total{type="insert"} 1` + "\n",
+		"# TYPE coderpad_broadcast_latency_seconds histogram\n",
+		`coderpad_broadcast_latency_seconds_bucket{le="+Inf"} `,
+	} {
+		if !strings.Contains(metrics, expected) {
+			t.Errorf("expected %q in the metrics; got:\n%s\n", expected, metrics)
+		}
+	}
+	if strings.Contains(metrics, "coderpad_received_bytes_total 0\n") || strings.Contains(metrics, "coderpad_sent_bytes_total 0\n") {
+		t.Errorf("expected traffic to be counted; got:\n%s\n", metrics)
+	}
+}
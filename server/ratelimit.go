@@ -0,0 +1,39 @@
+package main
+
+import "time"
+
+// rateLimiter is a token bucket limiting how many messages a client sends: it holds up to burst tokens,
+// refilled at rate tokens per second, and every message takes one.
+// A message arriving when the bucket is empty is delayed until a token is available, rather than dropped,
+// so no change is lost; every second in which a client's messages are delayed counts as a violation.
+type rateLimiter struct {
+	rate, burst float64
+	tokens      float64
+	last        time.Time
+
+	// violations counts the seconds in which messages were delayed, since the client last stayed within the limit.
+	violations    int
+	lastViolation time.Time
+}
+
+func newRateLimiter(rate float64, burst int, now time.Time) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+// take takes a token for a message received at now, and returns how long to delay the message,
+// and whether this is the first violation since the client last stayed within the limit.
+func (limiter *rateLimiter) take(now time.Time) (time.Duration, bool) {
+	limiter.tokens = min(limiter.burst, limiter.tokens+now.Sub(limiter.last).Seconds()*limiter.rate)
+	limiter.last = now
+	limiter.tokens--
+	if limiter.tokens >= 0 {
+		limiter.violations = 0
+		return 0, false
+	}
+	first := limiter.violations == 0
+	if first || now.Sub(limiter.lastViolation) >= time.Second {
+		limiter.violations++
+		limiter.lastViolation = now
+	}
+	return time.Duration(-limiter.tokens / limiter.rate * float64(time.Second)), first
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	start := time.Now()
+	limiter := newRateLimiter(1, 1, start)
+	for _, test := range []struct {
+		after      time.Duration
+		delay      time.Duration
+		first      bool
+		violations int
+	}{
+		{0, 0, false, 0},
+		{0, time.Second, true, 1},
+		// Still over the limit a second later: another violation.
+		{time.Second, time.Second, false, 2},
+		// Within the same second, only the delay grows.
+		{1500 * time.Millisecond, 1500 * time.Millisecond, false, 2},
+		// Once the bucket refilled, the client is back within the limit.
+		{10 * time.Second, 0, false, 0},
+	} {
+		delay, first := limiter.take(start.Add(test.after))
+		if delay != test.delay || first != test.first || limiter.violations != test.violations {
+			t.Errorf("take after %v mismatch; got = %v, %v, %v, expected = %v, %v, %v\n",
+				test.after, delay, first, limiter.violations, test.delay, test.first, test.violations)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/omesh-barhate/coderpad/commons"
+	"github.com/omesh-barhate/coderpad/crdt"
+)
+
+// defaultRoomID is the room clients join when they connect to "/" instead of "/pad/<id>".
+const defaultRoomID = "default"
+
+var roomIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// serverSiteID is the site of the characters the server inserts itself. Clients are issued site IDs from 1,
+// and validation rejects their inserts of characters of this site, so they never generate the same IDs.
+const serverSiteID = 0
+
+// errRejected wraps the error of a change the room's replica couldn't apply, e.g. an insert next to a missing character.
+var errRejected = errors.New("rejected")
+
+// siteIDReservation is the number of site IDs reserved in sessions.json at a time, so issuing site IDs to clients
+// without a session, or to returning ones, doesn't rewrite it on every connection.
+const siteIDReservation = 64
+
+// Room is an independent pad: it has its own clients, site IDs and broadcasts.
+// The server keeps its own replica of the room's document and metadata by applying every operation,
+// so it can answer joins directly, and the pad outlives its clients.
+// Rooms are owned by the hub goroutine.
+type Room struct {
+	ID string
+
+	document   *crdt.SyncedDocument
+	metadata   *crdt.Map
+	clients    map[uuid.UUID]*Client
+	nextSiteID int
+	// reservedSiteID is the highest site ID that may be issued before sessions.json is saved again.
+	reservedSiteID int
+	// clock is the clock of the characters the server inserts in the room, with serverSiteID.
+	clock int
+	// sessions maps the hashes of clients' session secrets to the site IDs issued to them.
+	sessions map[string]string
+	// store persists the room, if the server was started with a data directory.
+	store *roomStore
+
+	// sequence is the sequence number of the last change applied to the room,
+	// and history holds the latest changes, up to config.ResumeHistory of them, for clients resuming after a disconnection.
+	sequence uint64
+	history  []commons.Message
+}
+
+// newRoom creates a room. If persistence is enabled, its state is restored from the data directory.
+func newRoom(roomID string) (*Room, error) {
+	room := &Room{
+		ID:       roomID,
+		document: crdt.NewSyncedDocument(crdt.New()),
+		clients:  make(map[uuid.UUID]*Client),
+		metadata: crdt.NewMap(),
+		sessions: make(map[string]string),
+	}
+	if config.DataDir == "" {
+		return room, nil
+	}
+	store, state, err := openRoomStore(filepath.Join(config.DataDir, roomID), config.SnapshotEvery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore room %s: %w", roomID, err)
+	}
+	var lastSiteID int
+	if room.sessions, lastSiteID, err = store.loadSessions(); err != nil {
+		store.log.Close()
+		return nil, fmt.Errorf("failed to restore room %s: %w", roomID, err)
+	}
+	room.store = store
+	room.sequence = state.Sequence
+	room.document = crdt.NewSyncedDocument(state.Document)
+	room.metadata = state.Metadata
+	room.clock = state.Clock
+	room.advanceClock()
+	// Site IDs restart from the highest one issued or in the document, so they are never issued again,
+	// and restored characters' IDs are never generated again.
+	// The unissued IDs of the last reservation are skipped, as whether they were issued isn't known.
+	room.nextSiteID = max(lastSiteID, crdt.MaxSiteID(state.Document))
+	room.reservedSiteID = room.nextSiteID
+	return room, nil
+}
+
+// issueSiteID returns the site ID of the client with the given session, issuing a new one if the session is new or empty.
+func (room *Room) issueSiteID(session string) (string, error) {
+	if siteID, ok := room.sessions[session]; ok && session != "" {
+		return siteID, nil
+	}
+	room.nextSiteID++
+	siteID := strconv.Itoa(room.nextSiteID)
+	save := room.nextSiteID > room.reservedSiteID
+	if save {
+		room.reservedSiteID = room.nextSiteID + siteIDReservation
+	}
+	if session != "" {
+		room.sessions[session] = siteID
+		save = true
+	}
+	if save && room.store != nil {
+		if err := room.store.saveSessions(room.sessions, room.reservedSiteID); err != nil {
+			return siteID, fmt.Errorf("failed to persist the site ID: %w", err)
+		}
+	}
+	return siteID, nil
+}
+
+// apply updates the room's replica with a message received from one of its clients, and persists it.
+// It returns the message, with its sequence number if it changed the room.
+func (room *Room) apply(message commons.Message) (commons.Message, error) {
+	operation := message.Operation
+	positionBased := message.MessageType == "operation" && operation.Character == nil
+	switch {
+	case positionBased && operation.OperationType == "insert":
+		// The server generates a position-based insert's character, so its log and the other replicas integrate the same one.
+		character, err := room.insert(operation.Position, operation.Value, message.Username)
+		if err != nil {
+			return message, fmt.Errorf("%w: %w", errRejected, err)
+		}
+		message.Operation.Character = &character
+	case positionBased && operation.OperationType == "delete":
+		// Likewise, a position-based delete is resolved to the character at its position in the server's replica.
+		character, ok := room.document.Delete(operation.Position, message.Username)
+		if !ok {
+			return message, fmt.Errorf("%w: %w: no character at %d", errRejected, crdt.ErrOutOfBounds, operation.Position)
+		}
+		message.Operation.Character = &character
+	default:
+		if err := applyToReplica(room.document, room.metadata, message); err != nil {
+			return message, fmt.Errorf("%w: %w", errRejected, err)
+		}
+	}
+	if message.MessageType == commons.DocSyncMessage {
+		room.advanceClock()
+	}
+	if !changesState(message) {
+		return message, nil
+	}
+	return room.record(message)
+}
+
+// record numbers a change already applied to the room's replica, keeps it for resuming clients, and persists it.
+func (room *Room) record(message commons.Message) (commons.Message, error) {
+	room.sequence++
+	message.Sequence = room.sequence
+	room.history = append(room.history, message)
+	// Trim the history in batches, so it isn't copied on every change.
+	if len(room.history) > 2*config.ResumeHistory {
+		room.history = append([]commons.Message(nil), room.history[len(room.history)-config.ResumeHistory:]...)
+	}
+	if room.store == nil {
+		return message, nil
+	}
+	compact, err := room.store.append(message, room.clock)
+	if err != nil {
+		return message, fmt.Errorf("failed to persist: %w", err)
+	}
+	if compact {
+		if err := room.store.snapshot(room.document.Snapshot(), room.metadata, room.clock); err != nil {
+			return message, fmt.Errorf("failed to snapshot: %w", err)
+		}
+	}
+	return message, nil
+}
+
+// setContent applies edits planned by planContent, and records the resulting document as a single docSync,
+// which it returns for the room's clients. It reports false, without changing anything, if a character the edits
+// are anchored to is no longer in the document, which happens if the document was replaced since they were planned.
+func (room *Room) setContent(edits []contentEdit, author string) (commons.Message, bool, error) {
+	placeholders := make(map[string]bool)
+	for _, edit := range edits {
+		anchors := []string{edit.deleted}
+		if edit.inserted != nil {
+			anchors = []string{edit.inserted.PrevID, edit.inserted.NextID}
+		}
+		for _, anchor := range anchors {
+			if !placeholders[anchor] && !room.document.Contains(anchor) {
+				return commons.Message{}, false, nil
+			}
+		}
+		if edit.inserted != nil {
+			placeholders[edit.inserted.ID] = true
+		}
+	}
+
+	ids := make(map[string]string)
+	resolve := func(id string) string {
+		if resolved, ok := ids[id]; ok {
+			return resolved
+		}
+		return id
+	}
+	for _, edit := range edits {
+		if edit.inserted == nil {
+			room.document.IntegrateDelete(edit.deleted, author)
+			continue
+		}
+		character := *edit.inserted
+		ids[character.ID] = room.nextCharacterID()
+		character.ID, character.PrevID, character.NextID = ids[character.ID], resolve(character.PrevID), resolve(character.NextID)
+		if _, err := room.document.IntegrateInsert(character, author); err != nil {
+			return commons.Message{}, true, err
+		}
+	}
+	message, err := room.record(commons.Message{Username: author, MessageType: commons.DocSyncMessage, Document: room.document.Snapshot()})
+	return message, true, err
+}
+
+// insert inserts a character of the server's site at a visible position.
+func (room *Room) insert(position int, value, author string) (crdt.Character, error) {
+	return room.document.InsertWithID(position, value, room.nextCharacterID(), author)
+}
+
+// nextCharacterID returns the ID of the next character the server inserts.
+func (room *Room) nextCharacterID() string {
+	room.clock++
+	return fmt.Sprintf("%d.%d", serverSiteID, room.clock)
+}
+
+// advanceClock moves the server's clock past its characters in the document, which may have been replaced, e.g. by a docSync.
+func (room *Room) advanceClock() {
+	room.clock = max(room.clock, crdt.MaxClock(room.document.Snapshot(), serverSiteID))
+}
+
+// close snapshots the room, so restoring it doesn't replay its log, and closes its store.
+// The room is only kept in memory afterwards.
+func (room *Room) close() error {
+	if room.store == nil {
+		return nil
+	}
+	store := room.store
+	room.store = nil
+	if err := store.snapshot(room.document.Snapshot(), room.metadata, room.clock); err != nil {
+		store.log.Close()
+		return err
+	}
+	return store.log.Close()
+}
+
+// since returns the changes applied after the given sequence number, if they are all still in the history.
+func (room *Room) since(sequence uint64) ([]commons.Message, bool) {
+	if sequence > room.sequence {
+		// The client saw changes this room doesn't have, e.g. before the server restarted without persistence.
+		return nil, false
+	}
+	missed := room.sequence - sequence
+	if missed > uint64(min(len(room.history), config.ResumeHistory)) {
+		return nil, false
+	}
+	return room.history[len(room.history)-int(missed):], true
+}
+
+func changesState(message commons.Message) bool {
+	switch message.MessageType {
+	case "operation", commons.DocSyncMessage, commons.MetadataMessage:
+		return true
+	}
+	return false
+}
+
+// applyToReplica applies a message to a replica of a room's document and metadata.
+func applyToReplica(document *crdt.SyncedDocument, metadata *crdt.Map, message commons.Message) error {
+	switch message.MessageType {
+	case "operation":
+		return message.Operation.Apply(document, message.Username)
+	case commons.DocSyncMessage:
+		document.Replace(message.Document, message.Username)
+		if message.Metadata != nil {
+			metadata.Merge(message.Metadata)
+		}
+	case commons.MetadataMessage:
+		if message.MetadataOp != nil {
+			metadata.Apply(*message.MetadataOp)
+		}
+	}
+	return nil
+}
+
+// state returns a docSync message carrying the room's current document and metadata.
+func (room *Room) state() commons.Message {
+	return commons.Message{MessageType: commons.DocSyncMessage, Document: room.document.Snapshot(), Metadata: room.metadata.Clone(), Sequence: room.sequence}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/omesh-barhate/coderpad/commons"
+	"github.com/omesh-barhate/coderpad/crdt"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// newTestServer starts a hub and a server for it, both stopped at the end of the test.
+func newTestServer(t *testing.T) (*httptest.Server, *Hub) {
+	t.Helper()
+	hub := newHub()
+	go hub.run()
+	server := httptest.NewServer(withRequestLogging(newServeMux(hub)))
+	t.Cleanup(server.Close)
+	return server, hub
+}
+
+// dial connects a test client to path and returns it along with the siteID message it was sent.
+// The room state sent after it is left to be read by the caller.
+func dial(t *testing.T, server *httptest.Server, path string) (*websocket.Conn, commons.Message) {
+	t.Helper()
+	connection, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+path, nil)
+	if err != nil {
+		t.Fatalf("dial error: %v\n", err)
+	}
+	t.Cleanup(func() { connection.Close() })
+	siteIDMessage := readMessage(t, connection)
+	if siteIDMessage.MessageType != commons.SiteIDMessage {
+		t.Fatalf("expected a siteID message; got %+v\n", siteIDMessage)
+	}
+	return connection, siteIDMessage
+}
+
+// uniqueRoom returns a room path that no other test (or repeated run) uses.
+func uniqueRoom(name string) string {
+	return "/pad/" + name + "-" + uuid.NewString()
+}
+
+func readMessage(t *testing.T, connection *websocket.Conn) commons.Message {
+	t.Helper()
+	var message commons.Message
+	_ = connection.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := connection.ReadJSON(&message); err != nil {
+		t.Fatalf("read error: %v\n", err)
+	}
+	return message
+}
+
+// readUntil reads messages until one of the given type arrives.
+func readUntil(t *testing.T, connection *websocket.Conn, messageType commons.MessageType) commons.Message {
+	t.Helper()
+	for {
+		if message := readMessage(t, connection); message.MessageType == messageType {
+			return message
+		}
+	}
+}
+
+func TestRooms_Isolated(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	first, second := uniqueRoom("first"), uniqueRoom("second")
+	alice, aliceSite := dial(t, server, first)
+	bob, _ := dial(t, server, first)
+	carol, carolSite := dial(t, server, second)
+	readUntil(t, carol, commons.DocSyncMessage)
+	if aliceSite.Text != "1" || carolSite.Text != "1" {
+		t.Errorf("site IDs are not per room; got %s and %s\n", aliceSite.Text, carolSite.Text)
+	}
+
+	operation := commons.Message{MessageType: "operation", Operation: commons.Operation{OperationType: "insert", Position: 1, Value: "a"}}
+	if err := alice.WriteJSON(&operation); err != nil {
+		t.Fatalf("write error: %v\n", err)
+	}
+	// The server generates the character of a position-based insert, with its own site.
+	got := readUntil(t, bob, "operation").Operation
+	if got.Value != "a" || got.Character == nil || got.Character.ID != "0.1" {
+		t.Errorf("operation mismatch; got = %+v, expected = %q as character %v\n", got, "a", "0.1")
+	}
+
+	_ = carol.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	var message commons.Message
+	if err := carol.ReadJSON(&message); err == nil {
+		t.Errorf("client in another room received %+v\n", message)
+	}
+}
+
+func TestRooms_InvalidID(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	_, response, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/pad/not.valid", nil)
+	if err == nil || response == nil || response.StatusCode != 404 {
+		t.Errorf("expected a 404 for an invalid room; got %v\n", err)
+	}
+}
+
+func TestRooms_ServerReplica(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	room := uniqueRoom("replica")
+	alice, site := dial(t, server, room)
+	readUntil(t, alice, commons.DocSyncMessage)
+	document := crdt.NewSyncedDocument(crdt.New())
+	for i, value := range []string{"h", "i", "!"} {
+		character, err := document.InsertWithID(i+1, value, fmt.Sprintf("%s.%d", site.Text, i+1), "alice")
+		if err != nil {
+			t.Fatalf("error: %v\n", err)
+		}
+		operation := commons.Message{MessageType: "operation", Operation: commons.Operation{OperationType: "insert", Position: i + 1, Value: value, Character: &character}}
+		if err := alice.WriteJSON(&operation); err != nil {
+			t.Fatalf("write error: %v\n", err)
+		}
+	}
+	character, _ := document.Delete(3, "alice")
+	operation := commons.Message{MessageType: "operation", Operation: commons.Operation{OperationType: "delete", Position: 3, Character: &character}}
+	if err := alice.WriteJSON(&operation); err != nil {
+		t.Fatalf("write error: %v\n", err)
+	}
+	alice.Close()
+
+	// The joiner gets the document from the server even though nobody else is connected.
+	var state commons.Message
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		bob, _ := dial(t, server, room)
+		state = readUntil(t, bob, commons.DocSyncMessage)
+		bob.Close()
+		if cmp.Equal(state.Document, document.Snapshot()) {
+			break
+		}
+	}
+	if got, want := crdt.Content(state.Document), "hi"; got != want {
+		t.Errorf("content mismatch; got = %v, expected = %v\n", got, want)
+	}
+	if !cmp.Equal(state.Document, document.Snapshot()) {
+		t.Errorf("server replica differs from the client; diff = %v\n", cmp.Diff(state.Document, document.Snapshot()))
+	}
+}
+
+// setContent plans and applies the edits turning the room's document into content, and returns the docSync recording them.
+func setContent(t *testing.T, room *Room, content string) commons.Message {
+	t.Helper()
+	edits, err := planContent(room.document.Snapshot(), content)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	message, ok, err := room.setContent(edits, contentAuthor)
+	if !ok || err != nil {
+		t.Fatalf("setContent mismatch; got = %v (%v), expected = %v\n", ok, err, true)
+	}
+	return message
+}
+
+func TestRoom_ServerClock(t *testing.T) {
+	room, _ := persistedRoom(t, 0)
+	synced := crdt.NewSyncedDocument(crdt.New())
+	if _, err := synced.InsertWithID(1, "a", "0.5", "alice"); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if _, err := room.apply(commons.Message{MessageType: commons.DocSyncMessage, Document: synced.Snapshot()}); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	lastCharacter := func(room *Room, content string) string {
+		t.Helper()
+		return crdt.IthVisible(setContent(t, room, content).Document, len(content)).ID
+	}
+	// The server's characters continue after those of its site in the document it was sent.
+	if got, expected := lastCharacter(room, "ab"), "0.6"; got != expected {
+		t.Errorf("character ID mismatch; got = %v, expected = %v\n", got, expected)
+	}
+
+	restored, err := newRoom(room.ID)
+	if err != nil {
+		t.Fatalf("restore error: %v\n", err)
+	}
+	defer restored.store.log.Close()
+	if got, expected := lastCharacter(restored, "abc"), "0.7"; got != expected {
+		t.Errorf("restored character ID mismatch; got = %v, expected = %v\n", got, expected)
+	}
+}
+
+func TestRoom_ResolvesPositionBasedDeletes(t *testing.T) {
+	room, _ := persistedRoom(t, 0)
+	var inserted []commons.Message
+	for i, value := range []string{"a", "b"} {
+		message, err := room.apply(insertMessage(i+1, value))
+		if err != nil {
+			t.Fatalf("error: %v\n", err)
+		}
+		inserted = append(inserted, message)
+	}
+
+	deleteMessage := func(position int) commons.Message {
+		return commons.Message{MessageType: "operation", Operation: commons.Operation{OperationType: "delete", Position: position}}
+	}
+	message, err := room.apply(deleteMessage(1))
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	// The delete is forwarded and logged with the character it deleted, so every replica deletes the same one.
+	if got, expected := message.Operation.Character, inserted[0].Operation.Character; got == nil || got.ID != expected.ID {
+		t.Errorf("deleted character mismatch; got = %+v, expected = %v\n", got, expected.ID)
+	}
+	if got, expected := room.document.Content(), "b"; got != expected {
+		t.Errorf("content mismatch; got = %v, expected = %v\n", got, expected)
+	}
+	if _, err := room.apply(deleteMessage(2)); !errors.Is(err, errRejected) {
+		t.Errorf("error mismatch; got = %v, expected = %v\n", err, errRejected)
+	}
+}
+
+func TestRoom_SetContent(t *testing.T) {
+	room, _ := persistedRoom(t, 0)
+	setContent(t, room, "one\ntwo\n")
+	sequence := room.sequence
+
+	// Edits are planned against a snapshot, and still apply after a concurrent change to it.
+	edits, err := planContent(room.document.Snapshot(), "one\n2\n")
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if _, err := room.apply(insertMessage(1, ">")); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	message, ok, err := room.setContent(edits, contentAuthor)
+	if !ok || err != nil {
+		t.Fatalf("setContent mismatch; got = %v (%v), expected = %v\n", ok, err, true)
+	}
+	if got, expected := room.document.Content(), ">one\n2\n"; got != expected {
+		t.Errorf("content mismatch; got = %q, expected = %q\n", got, expected)
+	}
+	// The whole change is recorded, and sent to clients, as one docSync.
+	if message.MessageType != commons.DocSyncMessage || message.Sequence != sequence+2 || crdt.Content(message.Document) != ">one\n2\n" {
+		t.Errorf("message mismatch; got = %+v\n", message)
+	}
+
+	// Edits anchored to characters a docSync removed aren't applied.
+	edits, _ = planContent(room.document.Snapshot(), "three\n")
+	if _, err := room.apply(commons.Message{MessageType: commons.DocSyncMessage, Document: crdt.New()}); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if _, ok, _ := room.setContent(edits, contentAuthor); ok || room.document.Content() != "" {
+		t.Errorf("stale edits applied; got content = %q\n", room.document.Content())
+	}
+}
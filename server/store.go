@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/omesh-barhate/coderpad/commons"
+	"github.com/omesh-barhate/coderpad/crdt"
+)
+
+// Each room is persisted in its own directory under the data directory:
+//   - ops.log holds one line per message applied to the room since the last snapshot,
+//     formatted as "<crc32 of the JSON record> <JSON record>\n";
+//   - snapshot.json holds the room's document and metadata as of a sequence number;
+//   - sessions.json holds the highest site ID reserved for issuing, and maps the hashes of clients' session secrets to the site IDs issued to them.
+//
+// A record that was only partially written when the server died fails its checksum,
+// so restoring stops at the last complete record and the log is truncated there.
+// Snapshots and sessions are written to a temporary file and renamed into place, and records at or below
+// the snapshot's sequence number are skipped, so a crash during compaction loses nothing.
+
+const (
+	logFileName      = "ops.log"
+	snapshotFileName = "snapshot.json"
+	sessionsFileName = "sessions.json"
+)
+
+// siteRecord is the content of sessions.json.
+type siteRecord struct {
+	LastSiteID int               `json:"lastSiteID"`
+	Sessions   map[string]string `json:"sessions"`
+}
+
+// The records and snapshots carry the room's server clock, see Room.clock.
+type logRecord struct {
+	Sequence uint64          `json:"seq"`
+	Clock    int             `json:"clock"`
+	Message  commons.Message `json:"message"`
+}
+
+type roomSnapshot struct {
+	Sequence uint64        `json:"seq"`
+	Clock    int           `json:"clock"`
+	Document crdt.Document `json:"document"`
+	Metadata *crdt.Map     `json:"metadata"`
+}
+
+// roomStore is the on-disk log and snapshot of a single room. It is not safe for concurrent use.
+type roomStore struct {
+	directory     string
+	log           *os.File
+	sequence      uint64
+	sinceSnapshot int
+	snapshotEvery int
+}
+
+// openRoomStore opens (or creates) the store in directory and returns the room state it holds.
+func openRoomStore(directory string, snapshotEvery int) (*roomStore, roomSnapshot, error) {
+	state := roomSnapshot{Document: crdt.New(), Metadata: crdt.NewMap()}
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, state, err
+	}
+	content, err := os.ReadFile(filepath.Join(directory, snapshotFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, state, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(content, &state); err != nil {
+			return nil, state, fmt.Errorf("corrupt snapshot in %s: %w", directory, err)
+		}
+	}
+
+	log, err := os.OpenFile(filepath.Join(directory, logFileName), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, state, err
+	}
+	store := &roomStore{directory: directory, log: log, sequence: state.Sequence, snapshotEvery: snapshotEvery}
+	document := crdt.NewSyncedDocument(state.Document)
+	valid, err := store.replay(func(record logRecord) {
+		state.Clock = max(state.Clock, record.Clock)
+		if record.Sequence <= state.Sequence {
+			return
+		}
+		store.sequence = record.Sequence
+		store.sinceSnapshot++
+		_ = applyToReplica(document, state.Metadata, record.Message)
+	})
+	if err != nil {
+		log.Close()
+		return nil, state, err
+	}
+	// Drop a torn record at the end of the log, so new records are appended after the last good one.
+	if err := log.Truncate(valid); err != nil {
+		log.Close()
+		return nil, state, err
+	}
+	if _, err := log.Seek(valid, io.SeekStart); err != nil {
+		log.Close()
+		return nil, state, err
+	}
+	state.Sequence = store.sequence
+	state.Document = document.Snapshot()
+	return store, state, nil
+}
+
+// replay calls apply for every intact record in the log, and returns the offset just past the last one.
+func (store *roomStore) replay(apply func(logRecord)) (int64, error) {
+	if _, err := store.log.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	reader := bufio.NewReader(store.log)
+	var valid int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return valid, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		record, ok := decodeRecord(line)
+		if !ok {
+			return valid, nil
+		}
+		apply(record)
+		valid += int64(len(line))
+	}
+}
+
+func encodeRecord(record logRecord) ([]byte, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	line := strconv.AppendUint(nil, uint64(crc32.ChecksumIEEE(payload)), 16)
+	line = append(line, ' ')
+	line = append(line, payload...)
+	return append(line, '\n'), nil
+}
+
+func decodeRecord(line []byte) (logRecord, bool) {
+	var record logRecord
+	checksum, payload, found := bytes.Cut(bytes.TrimSuffix(line, []byte("\n")), []byte(" "))
+	if !found {
+		return record, false
+	}
+	expected, err := strconv.ParseUint(string(checksum), 16, 32)
+	if err != nil || uint32(expected) != crc32.ChecksumIEEE(payload) {
+		return record, false
+	}
+	return record, json.Unmarshal(payload, &record) == nil
+}
+
+// append durably records a message applied to the room.
+// It reports whether enough records have accumulated that the room should be snapshotted.
+func (store *roomStore) append(message commons.Message, clock int) (bool, error) {
+	line, err := encodeRecord(logRecord{Sequence: store.sequence + 1, Clock: clock, Message: message})
+	if err != nil {
+		return false, err
+	}
+	if _, err := store.log.Write(line); err != nil {
+		return false, err
+	}
+	if err := store.log.Sync(); err != nil {
+		return false, err
+	}
+	store.sequence++
+	store.sinceSnapshot++
+	return store.snapshotEvery > 0 && store.sinceSnapshot >= store.snapshotEvery, nil
+}
+
+// snapshot replaces the snapshot with the given state and empties the log.
+func (store *roomStore) snapshot(document crdt.Document, metadata *crdt.Map, clock int) error {
+	content, err := json.Marshal(roomSnapshot{Sequence: store.sequence, Clock: clock, Document: document, Metadata: metadata})
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomically(store.directory, snapshotFileName, content); err != nil {
+		return err
+	}
+	// The snapshot now covers every record, so the log can be emptied.
+	if err := store.log.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := store.log.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	store.sinceSnapshot = 0
+	return nil
+}
+
+// loadSessions returns the sessions and highest reserved site ID saved with saveSessions.
+func (store *roomStore) loadSessions() (map[string]string, int, error) {
+	record := siteRecord{Sessions: make(map[string]string)}
+	content, err := os.ReadFile(filepath.Join(store.directory, sessionsFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return record.Sessions, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := json.Unmarshal(content, &record); err != nil {
+		return nil, 0, fmt.Errorf("corrupt sessions in %s: %w", store.directory, err)
+	}
+	if record.Sessions == nil {
+		record.Sessions = make(map[string]string)
+	}
+	return record.Sessions, record.LastSiteID, nil
+}
+
+// saveSessions durably replaces the room's sessions and highest reserved site ID.
+func (store *roomStore) saveSessions(sessions map[string]string, lastSiteID int) error {
+	content, err := json.Marshal(siteRecord{LastSiteID: lastSiteID, Sessions: sessions})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(store.directory, sessionsFileName, content)
+}
+
+// writeFileAtomically replaces a file in directory with content, so that after a crash it holds either its old or its new content.
+func writeFileAtomically(directory, name string, content []byte) error {
+	temporary, err := os.CreateTemp(directory, name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporary.Name())
+	if _, err := temporary.Write(content); err != nil {
+		temporary.Close()
+		return err
+	}
+	if err := temporary.Sync(); err != nil {
+		temporary.Close()
+		return err
+	}
+	if err := temporary.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(temporary.Name(), filepath.Join(directory, name)); err != nil {
+		return err
+	}
+	return syncDirectory(directory)
+}
+
+func syncDirectory(directory string) error {
+	handle, err := os.Open(directory)
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+	return handle.Sync()
+}
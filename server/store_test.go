@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/omesh-barhate/coderpad/commons"
+	"github.com/omesh-barhate/coderpad/crdt"
+)
+
+// insertMessage returns a position-based insert, which (unlike an ID-based one) would show up twice if replayed twice.
+func insertMessage(position int, value string) commons.Message {
+	return commons.Message{MessageType: "operation", Operation: commons.Operation{OperationType: "insert", Position: position, Value: value}}
+}
+
+// persistedRoom creates a room persisted in a temporary data directory.
+func persistedRoom(t *testing.T, every int) (*Room, string) {
+	t.Helper()
+	previousDirectory, previousEvery := config.DataDir, config.SnapshotEvery
+	config.DataDir, config.SnapshotEvery = t.TempDir(), every
+	t.Cleanup(func() { config.DataDir, config.SnapshotEvery = previousDirectory, previousEvery })
+	room, err := newRoom("persisted")
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	t.Cleanup(func() { room.store.log.Close() })
+	return room, filepath.Join(config.DataDir, room.ID)
+}
+
+func restoredContent(t *testing.T, directory string) string {
+	t.Helper()
+	store, state, err := openRoomStore(directory, 0)
+	if err != nil {
+		t.Fatalf("restore error: %v\n", err)
+	}
+	store.log.Close()
+	return crdt.Content(state.Document)
+}
+
+func TestRoomStore_Restore(t *testing.T) {
+	room, directory := persistedRoom(t, 3)
+	for i, value := range []string{"h", "e", "l", "l", "o"} {
+		if _, err := room.apply(insertMessage(i+1, value)); err != nil {
+			t.Fatalf("error: %v\n", err)
+		}
+	}
+	metadataOp := crdt.NewMap().Put(commons.MetadataLanguage, "go", crdt.Timestamp{Wall: 1, Site: 1})
+	if _, err := room.apply(commons.Message{MessageType: commons.MetadataMessage, MetadataOp: &metadataOp}); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if _, err := os.Stat(filepath.Join(directory, snapshotFileName)); err != nil {
+		t.Errorf("expected a snapshot after compaction: %v\n", err)
+	}
+
+	restored, err := newRoom(room.ID)
+	if err != nil {
+		t.Fatalf("restore error: %v\n", err)
+	}
+	defer restored.store.log.Close()
+	if got, want := restored.document.Content(), "hello"; got != want {
+		t.Errorf("content mismatch; got = %v, expected = %v\n", got, want)
+	}
+	if language, _ := restored.metadata.Get(commons.MetadataLanguage); language != "go" {
+		t.Errorf("metadata mismatch; got = %v, expected = %v\n", language, "go")
+	}
+}
+
+func TestRoomStore_TornWrite(t *testing.T) {
+	room, directory := persistedRoom(t, 0)
+	for i, value := range []string{"a", "b", "c"} {
+		if _, err := room.apply(insertMessage(i+1, value)); err != nil {
+			t.Fatalf("error: %v\n", err)
+		}
+	}
+	logPath := filepath.Join(directory, logFileName)
+	complete, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if _, err := room.apply(insertMessage(4, "d")); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	withLast, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+
+	// Simulate the server dying at every point while writing the last record.
+	for cut := len(complete); cut < len(withLast); cut++ {
+		if err := os.WriteFile(logPath, withLast[:cut], 0600); err != nil {
+			t.Fatalf("error: %v\n", err)
+		}
+		if got, want := restoredContent(t, directory), "abc"; got != want {
+			t.Fatalf("(cut at %d) content mismatch; got = %v, expected = %v\n", cut, got, want)
+		}
+	}
+
+	// The torn record is dropped, so records appended afterwards are restored too.
+	store, _, err := openRoomStore(directory, 0)
+	if err != nil {
+		t.Fatalf("restore error: %v\n", err)
+	}
+	if _, err := store.append(insertMessage(4, "x"), 0); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	store.log.Close()
+	if got, want := restoredContent(t, directory), "abcx"; got != want {
+		t.Errorf("content mismatch; got = %v, expected = %v\n", got, want)
+	}
+}
+
+func TestRoomStore_CrashDuringCompaction(t *testing.T) {
+	room, directory := persistedRoom(t, 0)
+	for i, value := range []string{"a", "b"} {
+		if _, err := room.apply(insertMessage(i+1, value)); err != nil {
+			t.Fatalf("error: %v\n", err)
+		}
+	}
+	logPath := filepath.Join(directory, logFileName)
+	log, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if err := room.store.snapshot(room.document.Snapshot(), room.metadata, room.clock); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	// The server died after writing the snapshot but before the log was emptied.
+	if err := os.WriteFile(logPath, log, 0600); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if got, want := restoredContent(t, directory), "ab"; got != want {
+		t.Errorf("content mismatch; got = %v, expected = %v\n", got, want)
+	}
+}
+
+func TestRoomStore_Sessions(t *testing.T) {
+	room, _ := persistedRoom(t, 0)
+	issue := func(room *Room, session string) string {
+		t.Helper()
+		siteID, err := room.issueSiteID(session)
+		if err != nil {
+			t.Fatalf("error: %v\n", err)
+		}
+		return siteID
+	}
+	got := []string{issue(room, "a"), issue(room, "b"), issue(room, "a"), issue(room, "")}
+	if expected := []string{"1", "2", "1", "3"}; !cmp.Equal(got, expected) {
+		t.Errorf("site IDs mismatch; got = %v, expected = %v\n", got, expected)
+	}
+	// Returning sessions and clients without one are issued site IDs without saving the sessions again.
+	sessionsPath := filepath.Join(room.store.directory, sessionsFileName)
+	saved, err := os.ReadFile(sessionsPath)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if err := os.Remove(sessionsPath); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	issue(room, "b")
+	issue(room, "")
+	if _, err := os.Stat(sessionsPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("sessions saved for a returning session or none; err = %v\n", err)
+	}
+	if err := os.WriteFile(sessionsPath, saved, 0600); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+
+	restored, err := newRoom(room.ID)
+	if err != nil {
+		t.Fatalf("restore error: %v\n", err)
+	}
+	defer restored.store.log.Close()
+	// Sessions survive a restart, and no site ID issued before it is issued again, with a session or not.
+	got = []string{issue(restored, "b"), issue(restored, "c")}
+	if expected := []string{"2", strconv.Itoa(1 + siteIDReservation + 1)}; !cmp.Equal(got, expected) {
+		t.Errorf("restored site IDs mismatch; got = %v, expected = %v\n", got, expected)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// certificateReloader serves a certificate and key pair from disk, reloading it when either file changes,
+// so a renewed certificate is picked up without restarting the server.
+type certificateReloader struct {
+	certFile, keyFile string
+
+	mutex       sync.Mutex
+	certificate *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	reloader := &certificateReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// reload loads the pair if either file was modified since it was last loaded.
+// It must be called with the mutex held, or before the reloader is in use.
+func (reloader *certificateReloader) reload() error {
+	certInfo, err := os.Stat(reloader.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(reloader.keyFile)
+	if err != nil {
+		return err
+	}
+	if reloader.certificate != nil && certInfo.ModTime().Equal(reloader.certModTime) && keyInfo.ModTime().Equal(reloader.keyModTime) {
+		return nil
+	}
+	certificate, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return err
+	}
+	reloader.certificate = &certificate
+	reloader.certModTime, reloader.keyModTime = certInfo.ModTime(), keyInfo.ModTime()
+	return nil
+}
+
+// getCertificate implements tls.Config.GetCertificate.
+// If the files were changed but can't be loaded (e.g. only one of them was replaced so far), the previous pair is kept.
+func (reloader *certificateReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+	if err := reloader.reload(); err != nil {
+		slog.Warn("failed to reload the certificate", slog.String("cert", reloader.certFile), slog.Any("error", err))
+	}
+	return reloader.certificate, nil
+}
+
+// newTLSConfig returns the TLS configuration serving the given certificate.
+// If clientCAFile is set, clients must present a certificate signed by one of the CAs in it.
+func newTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	reloader, err := newCertificateReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}
+	if clientCAFile == "" {
+		return config, nil
+	}
+	content, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no certificates found in %s", clientCAFile)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}
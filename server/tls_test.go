@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/omesh-barhate/coderpad/commons"
+)
+
+// testAuthority is a locally generated CA, issuing certificates for tests.
+type testAuthority struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	pool        *x509.CertPool
+	pem         []byte
+}
+
+func newTestAuthority(t *testing.T) *testAuthority {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "coderpad test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+	return &testAuthority{certificate: certificate, key: key, pool: pool, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue writes a certificate and key for 127.0.0.1, with the given serial number, to directory.
+func (authority *testAuthority) issue(t *testing.T, directory string, serial int64, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, authority.certificate, &key.PublicKey, authority.key)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	certFile, keyFile := filepath.Join(directory, "cert.pem"), filepath.Join(directory, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	return certFile, keyFile
+}
+
+func newTLSTestServer(t *testing.T, config *tls.Config) *httptest.Server {
+	t.Helper()
+	hub := newHub()
+	go hub.run()
+	server := httptest.NewUnstartedServer(newServeMux(hub))
+	// StartTLS would serve its own certificate, rather than the configuration's.
+	server.Listener = tls.NewListener(server.Listener, config)
+	server.Start()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func dialTLS(server *httptest.Server, config *tls.Config) (*websocket.Conn, *tls.ConnectionState, error) {
+	dialer := websocket.Dialer{TLSClientConfig: config, HandshakeTimeout: 2 * time.Second}
+	connection, _, err := dialer.Dial("wss"+strings.TrimPrefix(server.URL, "http")+uniqueRoom("tls"), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	state := connection.UnderlyingConn().(*tls.Conn).ConnectionState()
+	// Wait until the server registered the client, so its handler doesn't outlive the test.
+	var message commons.Message
+	_ = connection.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := connection.ReadJSON(&message); err != nil {
+		connection.Close()
+		return nil, nil, err
+	}
+	return connection, &state, nil
+}
+
+func TestTLS_CertificateReload(t *testing.T) {
+	authority := newTestAuthority(t)
+	directory := t.TempDir()
+	certFile, keyFile := authority.issue(t, directory, 100, x509.ExtKeyUsageServerAuth)
+	config, err := newTLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	server := newTLSTestServer(t, config)
+	clientConfig := &tls.Config{RootCAs: authority.pool}
+
+	connection, state, err := dialTLS(server, clientConfig)
+	if err != nil {
+		t.Fatalf("dial error: %v\n", err)
+	}
+	connection.Close()
+	if got := state.PeerCertificates[0].SerialNumber.Int64(); got != 100 {
+		t.Errorf("serial mismatch; got = %v, expected = %v\n", got, 100)
+	}
+
+	authority.issue(t, directory, 200, x509.ExtKeyUsageServerAuth)
+	// Make sure the change is visible even on file systems with coarse modification times.
+	later := time.Now().Add(time.Minute)
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.Chtimes(file, later, later); err != nil {
+			t.Fatalf("error: %v\n", err)
+		}
+	}
+	connection, state, err = dialTLS(server, clientConfig)
+	if err != nil {
+		t.Fatalf("dial error after reload: %v\n", err)
+	}
+	connection.Close()
+	if got := state.PeerCertificates[0].SerialNumber.Int64(); got != 200 {
+		t.Errorf("serial mismatch after reload; got = %v, expected = %v\n", got, 200)
+	}
+
+	// A broken pair is ignored, and the last good one is still served.
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0600); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if connection, _, err = dialTLS(server, clientConfig); err != nil {
+		t.Fatalf("dial error with a broken key file: %v\n", err)
+	}
+	connection.Close()
+}
+
+func TestTLS_ClientCertificates(t *testing.T) {
+	authority := newTestAuthority(t)
+	serverDirectory, clientDirectory := t.TempDir(), t.TempDir()
+	certFile, keyFile := authority.issue(t, serverDirectory, 1, x509.ExtKeyUsageServerAuth)
+	clientCAFile := filepath.Join(serverDirectory, "ca.pem")
+	if err := os.WriteFile(clientCAFile, authority.pem, 0600); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	config, err := newTLSConfig(certFile, keyFile, clientCAFile)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	server := newTLSTestServer(t, config)
+
+	if connection, _, err := dialTLS(server, &tls.Config{RootCAs: authority.pool}); err == nil {
+		connection.Close()
+		t.Errorf("expected a client without a certificate to be rejected\n")
+	}
+
+	clientCertFile, clientKeyFile := authority.issue(t, clientDirectory, 2, x509.ExtKeyUsageClientAuth)
+	clientCertificate, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	connection, _, err := dialTLS(server, &tls.Config{RootCAs: authority.pool, Certificates: []tls.Certificate{clientCertificate}})
+	if err != nil {
+		t.Fatalf("dial error with a client certificate: %v\n", err)
+	}
+	connection.Close()
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"cmp"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/omesh-barhate/coderpad/commons"
+)
+
+// An access token is "<payload>.<signature>", where the payload is the base64url-encoded JSON claims,
+// and the signature is the base64url-encoded HMAC-SHA256 of the payload, keyed with the server's token secret.
+
+var (
+	errTokenMissing   = errors.New("missing access token")
+	errTokenMalformed = errors.New("malformed access token")
+	errTokenSignature = errors.New("invalid access token signature")
+	errTokenExpired   = errors.New("access token expired")
+	errTokenRoom      = errors.New("access token is not valid for this room")
+)
+
+type tokenClaims struct {
+	Room      string       `json:"room"`
+	ExpiresAt int64        `json:"exp"`
+	Role      commons.Role `json:"role,omitempty"`
+}
+
+func signToken(secret []byte, claims tokenClaims) (string, error) {
+	content, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(content)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(tokenSignature(secret, payload)), nil
+}
+
+func tokenSignature(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// verifyToken checks that a token was signed with secret, has not expired at now, and grants access to roomID.
+func verifyToken(secret []byte, token, roomID string, now time.Time) (tokenClaims, error) {
+	var claims tokenClaims
+	if token == "" {
+		return claims, errTokenMissing
+	}
+	payload, encodedSignature, found := strings.Cut(token, ".")
+	if !found {
+		return claims, errTokenMalformed
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return claims, errTokenMalformed
+	}
+	if !hmac.Equal(signature, tokenSignature(secret, payload)) {
+		return claims, errTokenSignature
+	}
+	content, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return claims, errTokenMalformed
+	}
+	if err := json.Unmarshal(content, &claims); err != nil {
+		return claims, errTokenMalformed
+	}
+	if !now.Before(time.Unix(claims.ExpiresAt, 0)) {
+		return claims, errTokenExpired
+	}
+	if claims.Room != roomID {
+		return claims, errTokenRoom
+	}
+	return claims, nil
+}
+
+// requestToken returns the token sent as an "Authorization: Bearer" header, or as the "token" query parameter.
+func requestToken(request *http.Request) string {
+	if token, found := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer "); found {
+		return token
+	}
+	return request.URL.Query().Get("token")
+}
+
+// authorize checks the request's token for the room, if tokens are required, and returns its claims.
+func authorize(request *http.Request, roomID string) (tokenClaims, error) {
+	if config.TokenSecret == "" {
+		return tokenClaims{}, nil
+	}
+	return verifyToken([]byte(config.TokenSecret), requestToken(request), roomID, time.Now())
+}
+
+// requestRole returns the role a connection is granted: the token's role, if any, unless the client asked to be a viewer.
+func requestRole(request *http.Request, claims tokenClaims) commons.Role {
+	if commons.Role(request.URL.Query().Get("role")) == commons.RoleViewer || claims.Role == commons.RoleViewer {
+		return commons.RoleViewer
+	}
+	return commons.RoleEditor
+}
+
+// runTokenCommand implements "coderpad-server token", which prints a token for a room.
+func runTokenCommand(arguments []string) error {
+	flags := flag.NewFlagSet("token", flag.ExitOnError)
+	secret := flags.String("token-secret", "", "Secret to sign the token with (default $"+environmentVariable("token-secret")+")")
+	room := flags.String("room", defaultRoomID, "Room the token grants access to")
+	ttl := flags.Duration("ttl", 24*time.Hour, "How long the token is valid for")
+	role := flags.String("role", string(commons.RoleEditor), "Role the token grants: \"editor\" or \"viewer\"")
+	_ = flags.Parse(arguments)
+
+	*secret = cmp.Or(*secret, os.Getenv(environmentVariable("token-secret")))
+	if *secret == "" {
+		return errors.New("a token secret is required")
+	}
+	if !roomIDPattern.MatchString(*room) {
+		return fmt.Errorf("invalid room %q", *room)
+	}
+	if *ttl <= 0 {
+		return errors.New("-ttl must be positive")
+	}
+	if commons.Role(*role) != commons.RoleEditor && commons.Role(*role) != commons.RoleViewer {
+		return fmt.Errorf("invalid role %q", *role)
+	}
+	token, err := signToken([]byte(*secret), tokenClaims{Room: *room, ExpiresAt: time.Now().Add(*ttl).Unix(), Role: commons.Role(*role)})
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
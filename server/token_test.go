@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/omesh-barhate/coderpad/commons"
+)
+
+func TestVerifyToken(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1700000000, 0)
+	token, err := signToken(secret, tokenClaims{Room: "interview", ExpiresAt: now.Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	payload, signature, _ := strings.Cut(token, ".")
+	forged, _ := signToken(secret, tokenClaims{Room: "other", ExpiresAt: now.Add(time.Hour).Unix()})
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+
+	tests := []struct {
+		name   string
+		secret []byte
+		token  string
+		room   string
+		now    time.Time
+		want   error
+	}{
+		{"valid", secret, token, "interview", now, nil},
+		{"missing", secret, "", "interview", now, errTokenMissing},
+		{"malformed", secret, payload, "interview", now, errTokenMalformed},
+		{"wrong secret", []byte("other"), token, "interview", now, errTokenSignature},
+		{"tampered claims", secret, forgedPayload + "." + signature, "interview", now, errTokenSignature},
+		{"expired", secret, token, "interview", now.Add(time.Hour), errTokenExpired},
+		{"wrong room", secret, token, "other", now, errTokenRoom},
+	}
+	for _, test := range tests {
+		if _, err := verifyToken(test.secret, test.token, test.room, test.now); !errors.Is(err, test.want) {
+			t.Errorf("(%s) error mismatch; got = %v, expected = %v\n", test.name, err, test.want)
+		}
+	}
+}
+
+func TestHandleWebSocket_Token(t *testing.T) {
+	previous := config.TokenSecret
+	config.TokenSecret = "secret"
+	t.Cleanup(func() { config.TokenSecret = previous })
+	server, _ := newTestServer(t)
+	path := uniqueRoom("private")
+	token, err := signToken([]byte(config.TokenSecret), tokenClaims{Room: strings.TrimPrefix(path, "/pad/"), ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + path
+
+	_, response, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || response == nil || response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected a 401 without a token; got %v\n", err)
+	}
+	connection, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer " + token}})
+	if err != nil {
+		t.Fatalf("dial error with a token: %v\n", err)
+	}
+	defer connection.Close()
+	readUntil(t, connection, commons.SiteIDMessage)
+}
+
+func TestRequestRole(t *testing.T) {
+	tests := []struct {
+		query  string
+		claims tokenClaims
+		want   commons.Role
+	}{
+		{"", tokenClaims{}, commons.RoleEditor},
+		{"?role=viewer", tokenClaims{}, commons.RoleViewer},
+		{"", tokenClaims{Role: commons.RoleViewer}, commons.RoleViewer},
+		{"?role=editor", tokenClaims{Role: commons.RoleViewer}, commons.RoleViewer},
+		{"", tokenClaims{Role: commons.RoleEditor}, commons.RoleEditor},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, "/pad/room"+test.query, nil)
+		if got := requestRole(request, test.claims); got != test.want {
+			t.Errorf("(%q, %+v) role mismatch; got = %v, expected = %v\n", test.query, test.claims, got, test.want)
+		}
+	}
+}
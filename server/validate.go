@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/omesh-barhate/coderpad/commons"
+)
+
+// Messages from clients are checked against the schema of their type before they reach the hub,
+// and malformed ones are answered with an error message rather than applied or forwarded.
+
+// errMalformedMessage wraps the error of a message that isn't valid JSON.
+var errMalformedMessage = errors.New("malformed message")
+
+// errMessageTooLarge is returned for a message larger than the client's maxMessageSize.
+var errMessageTooLarge = errors.New("message too large")
+
+var characterIDPattern = regexp.MustCompile(`^[0-9]+\.[0-9]+$`)
+
+const maxUsernameLength = 64
+
+// validateMessage checks a message received from a client, and returns the error to reply with if it is invalid.
+func validateMessage(message commons.Message) *commons.Error {
+	invalid := func(format string, args ...any) *commons.Error {
+		return &commons.Error{Code: commons.ErrorInvalidMessage, Text: fmt.Sprintf("%s: %s", message.MessageType, fmt.Sprintf(format, args...))}
+	}
+	switch message.MessageType {
+	case "operation":
+		return validateOperation(message.Operation)
+	case commons.DocSyncMessage:
+		if violations := message.Document.Validate(); len(violations) > 0 {
+			return invalid("invalid document: %v", violations[0])
+		}
+	case commons.JoinMessage:
+		if !utf8.ValidString(message.Username) || utf8.RuneCountInString(message.Username) > maxUsernameLength {
+			return invalid("username must be UTF-8, and at most %d characters", maxUsernameLength)
+		}
+	case commons.DigestMessage:
+		if message.Digest == nil {
+			return invalid("missing digest")
+		}
+	case commons.StateReqMessage:
+	case commons.MetadataMessage:
+		if message.MetadataOp == nil {
+			return invalid("missing metadata operation")
+		}
+	case commons.PresenceMessage:
+		if message.Presence == nil {
+			return invalid("missing presence")
+		}
+	case commons.SiteIDMessage, commons.LeaveMessage, commons.AckMessage, commons.ErrorMessage:
+		return &commons.Error{Code: commons.ErrorUnknownType, Text: fmt.Sprintf("only the server sends %q messages", message.MessageType)}
+	default:
+		return &commons.Error{Code: commons.ErrorUnknownType, Text: fmt.Sprintf("unknown message type %q", message.MessageType)}
+	}
+	return nil
+}
+
+func validateOperation(operation commons.Operation) *commons.Error {
+	invalid := func(format string, args ...any) *commons.Error {
+		return &commons.Error{Code: commons.ErrorInvalidOperation, Text: fmt.Sprintf(format, args...)}
+	}
+	if operation.OperationType != "insert" && operation.OperationType != "delete" {
+		return invalid("unknown operation type %q", operation.OperationType)
+	}
+	if operation.Position < 0 {
+		return invalid("negative position %d", operation.Position)
+	}
+	value := operation.Value
+	if character := operation.Character; character != nil {
+		if !characterIDPattern.MatchString(character.ID) {
+			return invalid("invalid character ID %q", character.ID)
+		}
+		if operation.OperationType == "delete" {
+			return nil
+		}
+		if site, _, _ := strings.Cut(character.ID, "."); site == strconv.Itoa(serverSiteID) {
+			return invalid("site %s is reserved for the server", site)
+		}
+		if character.PrevID == "" || character.NextID == "" {
+			return invalid("character %s has no neighbours", character.ID)
+		}
+		value = character.Value
+	} else if operation.OperationType == "delete" {
+		return nil
+	}
+	if utf8.RuneCountInString(value) != 1 || !utf8.ValidString(value) {
+		return invalid("an insert must insert exactly one character, got %q", value)
+	}
+	return nil
+}
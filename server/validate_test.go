@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/omesh-barhate/coderpad/commons"
+	"github.com/omesh-barhate/coderpad/crdt"
+)
+
+func TestValidateMessage(t *testing.T) {
+	character := func(id, value string) *crdt.Character {
+		return &crdt.Character{ID: id, Visible: true, Value: value, PrevID: "start", NextID: "end"}
+	}
+	broken := crdt.New()
+	broken.Characters = broken.Characters[:1]
+	for _, test := range []struct {
+		name     string
+		message  commons.Message
+		expected commons.ErrorCode
+	}{
+		{"insert", commons.Message{MessageType: "operation", Operation: commons.Operation{OperationType: "insert", Position: 1, Character: character("1.1", "a")}}, ""},
+		{"position-based insert", insertMessage(1, "a"), ""},
+		{"server's site", commons.Message{MessageType: "operation", Operation: commons.Operation{OperationType: "insert", Position: 1, Character: character("0.1", "a")}}, commons.ErrorInvalidOperation},
+		{"delete of a server's character", commons.Message{MessageType: "operation", Operation: commons.Operation{OperationType: "delete", Position: 1, Character: &crdt.Character{ID: "0.1"}}}, ""},
+		{"delete", commons.Message{MessageType: "operation", Operation: commons.Operation{OperationType: "delete", Position: 1, Character: &crdt.Character{ID: "1.1"}}}, ""},
+		{"unknown operation", commons.Message{MessageType: "operation", Operation: commons.Operation{OperationType: "move"}}, commons.ErrorInvalidOperation},
+		{"negative position", insertMessage(-1, "a"), commons.ErrorInvalidOperation},
+		{"several characters", insertMessage(1, "ab"), commons.ErrorInvalidOperation},
+		{"invalid character ID", commons.Message{MessageType: "operation", Operation: commons.Operation{OperationType: "insert", Character: character("start", "a")}}, commons.ErrorInvalidOperation},
+		{"docSync", commons.Message{MessageType: commons.DocSyncMessage, Document: crdt.New()}, ""},
+		{"broken docSync", commons.Message{MessageType: commons.DocSyncMessage, Document: broken}, commons.ErrorInvalidMessage},
+		{"presence", commons.Message{MessageType: commons.PresenceMessage, Presence: &commons.Presence{}}, ""},
+		{"missing presence", commons.Message{MessageType: commons.PresenceMessage}, commons.ErrorInvalidMessage},
+		{"state request", commons.Message{MessageType: commons.StateReqMessage}, ""},
+		// The server answers joins from its replica, so it never asks clients for their document.
+		{"document request", commons.Message{MessageType: "docReq"}, commons.ErrorUnknownType},
+		{"server-only type", commons.Message{MessageType: commons.AckMessage}, commons.ErrorUnknownType},
+		{"unknown type", commons.Message{MessageType: "bogus"}, commons.ErrorUnknownType},
+	} {
+		var got commons.ErrorCode
+		if err := validateMessage(test.message); err != nil {
+			got = err.Code
+		}
+		if got != test.expected {
+			t.Errorf("%s: error code mismatch; got = %q, expected = %q\n", test.name, got, test.expected)
+		}
+	}
+}